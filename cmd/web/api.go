@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/Avixph/learn-go-snippetbox/internal/validator"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Define an apiSnippet type which holds the JSON representation of a snippet.
+// Keeping it separate from models.Snippet means the API response format
+// doesn't change by accident when the model does.
+type apiSnippet struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Author    string    `json:"author"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Language  string    `json:"language"`
+	Tags      []string  `json:"tags"`
+	CreatedOn time.Time `json:"created_on"`
+	UpdatedOn time.Time `json:"updated_on"`
+	ExpiresOn time.Time `json:"expires_on"`
+}
+
+// The newAPISnippet() func converts a models.Snippet to its JSON
+// representation.
+func newAPISnippet(s *models.Snippet) apiSnippet {
+	tags := s.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return apiSnippet{
+		ID:        s.ID,
+		UserID:    s.UserID,
+		Author:    s.Author,
+		Title:     s.Title,
+		Content:   s.Content,
+		Language:  s.Language,
+		Tags:      tags,
+		CreatedOn: s.CreatedOn,
+		UpdatedOn: s.UpdatedOn,
+		ExpiresOn: s.ExpiresOn,
+	}
+}
+
+// Define an apiSnippetInput type to hold the JSON body of create and update
+// requests.
+type apiSnippetInput struct {
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Language string   `json:"language"`
+	Tags     []string `json:"tags"`
+	Expires  int      `json:"expires"`
+}
+
+// The form() method copies the input into a snippetForm, so that the API uses
+// exactly the same validation rules as the HTML forms. The language defaults
+// to "plaintext" when it isn't given.
+func (input apiSnippetInput) form() snippetForm {
+	if input.Language == "" {
+		input.Language = "plaintext"
+	}
+
+	return snippetForm{
+		Title:    input.Title,
+		Content:  input.Content,
+		Language: input.Language,
+		Tags:     strings.Join(input.Tags, ","),
+		Expires:  input.Expires,
+	}
+}
+
+// The apiGetSnippet helper is the JSON equivalent of getSnippet. It retrieves
+// the snippet with the ID given in the URL, sending a JSON 404 Not Found
+// response if there isn't one.
+func (app *application) apiGetSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// The apiOwnedSnippet helper works like apiGetSnippet, but also checks that
+// the snippet belongs to the authenticated user.
+func (app *application) apiOwnedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	snippet, ok := app.apiGetSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if snippet.UserID != app.authenticatedUserID(r) {
+		app.apiError(w, r, http.StatusForbidden, "you do not have permission to change this snippet")
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// Define an apiSnippetList handler func which sends a page of the latest
+// snippets. It accepts the same "after", "before", "limit" and "tags" query
+// string parameters as the home page, and the cursors for the neighbouring
+// pages are included in the response.
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	after, before, limit, err := readPageParams(r)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, "invalid pagination parameters")
+		return
+	}
+
+	tags := validator.NormalizeTags(r.URL.Query().Get("tags"))
+
+	page, err := app.snippets.Latest(tags, after, before, limit)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	snippets := make([]apiSnippet, 0, len(page.Snippets))
+	for _, s := range page.Snippets {
+		snippets = append(snippets, newAPISnippet(s))
+	}
+
+	data := envelope{"snippets": snippets}
+	if page.Next != nil {
+		data["next"] = page.Next.String()
+	}
+	if page.Prev != nil {
+		data["prev"] = page.Prev.String()
+	}
+
+	app.writeJSON(w, r, http.StatusOK, data)
+}
+
+// Define an apiSnippetGet handler func which sends a single snippet.
+func (app *application) apiSnippetGet(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiGetSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": newAPISnippet(snippet)})
+}
+
+// Define an apiSnippetCreate handler func which creates a snippet owned by
+// the authenticated user. On success it sends a 201 Created response with
+// the new snippet's ID and a Location header pointing at it.
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var input apiSnippetInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form := input.form()
+	form.validate()
+
+	if !form.Valid() {
+		app.apiValidationError(w, r, form.Validator)
+		return
+	}
+
+	id, err := app.snippets.Insert(app.authenticatedUserID(r), form.Title, form.Content, form.Language, form.TagList, form.Expires)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.metrics.snippetsCreated.Inc()
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%v", id))
+	app.writeJSON(w, r, http.StatusCreated, envelope{"id": id})
+}
+
+// Define an apiSnippetUpdate handler func which replaces the fields of a
+// snippet owned by the authenticated user, and sends back the updated
+// snippet.
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var input apiSnippetInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form := input.form()
+	form.validate()
+
+	if !form.Valid() {
+		app.apiValidationError(w, r, form.Validator)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	err = app.snippets.Update(snippet.ID, userID, form.Title, form.Content, form.Language, form.TagList, form.Expires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	snippet, err = app.snippets.Get(snippet.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": newAPISnippet(snippet)})
+}
+
+// Define an apiSnippetDelete handler func which deletes a snippet owned by
+// the authenticated user, and sends a 204 No Content response.
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(snippet.ID, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/models/mocks"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Define the IDs of the mock user and snippets used in the API tests.
+var (
+	mockUserID         = uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	mockSnippetID      = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	mockOtherSnippetID = "6ba7b812-9dad-11d1-80b4-00c04fd430c8"
+)
+
+// The serveAPI helper calls an API handler directly with the given method,
+// ":id" parameter and JSON body. If userID isn't uuid.Nil the request is
+// marked as coming from that user, which lets us test the write endpoints
+// without going through an authentication middleware.
+func serveAPI(t *testing.T, h http.HandlerFunc, method, id, body string, userID uuid.UUID) (int, string) {
+	r, err := http.NewRequest(method, "/api/v1/snippets", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	ctx := context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: id}})
+	r = r.WithContext(ctx)
+	if userID != uuid.Nil {
+		r = contextWithUser(r, userID)
+	}
+
+	rr := httptest.NewRecorder()
+	h(rr, r)
+
+	return rr.Code, rr.Body.String()
+}
+
+func TestAPISnippetList(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Latest",
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"title":"An old silent pond"`,
+		},
+		{
+			name:     "Next cursor",
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"next":`,
+		},
+		{
+			name:     "Filtered by tag",
+			urlPath:  "/api/v1/snippets?tags=haiku",
+			wantCode: http.StatusOK,
+			wantBody: `"tags":["haiku","poetry"]`,
+		},
+		{
+			name:     "Invalid cursor",
+			urlPath:  "/api/v1/snippets?after=bad",
+			wantCode: http.StatusBadRequest,
+			wantBody: `"error":"invalid pagination parameters"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Content-Type"), "application/json")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetGet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid ID",
+			urlPath:  "/api/v1/snippets/" + mockSnippetID,
+			wantCode: http.StatusOK,
+			wantBody: `"content":"An old silent pond..."`,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/api/v1/snippets/3fa80338-89b5-407e-b294-c3ac68238070",
+			wantCode: http.StatusNotFound,
+			wantBody: `"error":"snippet not found"`,
+		},
+		{
+			name:     "Invalid ID",
+			urlPath:  "/api/v1/snippets/foo",
+			wantCode: http.StatusNotFound,
+			wantBody: `"error":"snippet not found"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPIRequiresAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		urlPath     string
+		contentType string
+		wantCode    int
+	}{
+		{"Create", http.MethodPost, "/api/v1/snippets", "application/json", http.StatusUnauthorized},
+		{"Update", http.MethodPut, "/api/v1/snippets/" + mockSnippetID, "application/json", http.StatusUnauthorized},
+		{"Delete", http.MethodDelete, "/api/v1/snippets/" + mockSnippetID, "", http.StatusUnauthorized},
+		{"Wrong content type", http.MethodPost, "/api/v1/snippets", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+		})
+	}
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	body := `{"title": "Haiku", "content": "A frog jumps", "expires": 7}`
+
+	tests := []struct {
+		name          string
+		method        string
+		urlPath       string
+		authorization string
+		wantCode      int
+		wantBody      string
+	}{
+		{
+			name:          "Write token creates",
+			method:        http.MethodPost,
+			urlPath:       "/api/v1/snippets",
+			authorization: "Bearer " + mocks.WriteToken,
+			wantCode:      http.StatusCreated,
+		},
+		{
+			name:          "Write token deletes",
+			method:        http.MethodDelete,
+			urlPath:       "/api/v1/snippets/" + mockSnippetID,
+			authorization: "Bearer " + mocks.WriteToken,
+			wantCode:      http.StatusNoContent,
+		},
+		{
+			name:          "Read token reads",
+			method:        http.MethodGet,
+			urlPath:       "/api/v1/snippets/" + mockSnippetID,
+			authorization: "Bearer " + mocks.ReadToken,
+			wantCode:      http.StatusOK,
+		},
+		{
+			name:          "Read token can't create",
+			method:        http.MethodPost,
+			urlPath:       "/api/v1/snippets",
+			authorization: "Bearer " + mocks.ReadToken,
+			wantCode:      http.StatusForbidden,
+			wantBody:      "this token does not have the write scope",
+		},
+		{
+			name:          "Unknown token",
+			method:        http.MethodGet,
+			urlPath:       "/api/v1/snippets",
+			authorization: "Bearer sbx_unknown",
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      "invalid or missing authentication token",
+		},
+		{
+			name:          "Wrong scheme",
+			method:        http.MethodGet,
+			urlPath:       "/api/v1/snippets",
+			authorization: "Basic " + mocks.WriteToken,
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      "invalid or missing authentication token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", tt.authorization)
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+
+			b, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+			assert.StringContains(t, string(b), tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid submission",
+			body:     `{"title": "Haiku", "content": "A frog jumps", "tags": ["poetry"], "expires": 7}`,
+			wantCode: http.StatusCreated,
+			wantBody: `"id":`,
+		},
+		{
+			name:     "Blank title",
+			body:     `{"title": "", "content": "A frog jumps", "expires": 7}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"fields":{"title":"This field cannot be blank!"}`,
+		},
+		{
+			name:     "Invalid expires",
+			body:     `{"title": "Haiku", "content": "A frog jumps", "expires": 2}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"expires":"This field must equal 1, 7 or 365!"`,
+		},
+		{
+			name:     "Unknown field",
+			body:     `{"title": "Haiku", "colour": "green"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `"error":"body contains unknown field \"colour\""`,
+		},
+		{
+			name:     "Badly-formed JSON",
+			body:     `{"title": "Haiku"`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `"error":"body contains badly-formed JSON"`,
+		},
+		{
+			name:     "Wrong type",
+			body:     `{"title": "Haiku", "expires": "7"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `wrong JSON type for field \"expires\"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveAPI(t, app.apiSnippetCreate, http.MethodPost, "", tt.body, mockUserID)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetUpdate(t *testing.T) {
+	app := newTestApplication(t)
+
+	validBody := `{"title": "An old silent pond", "content": "A frog jumps", "language": "plaintext", "expires": 365}`
+
+	tests := []struct {
+		name     string
+		id       string
+		body     string
+		wantCode int
+	}{
+		{"Valid submission", mockSnippetID, validBody, http.StatusOK},
+		{"Invalid language", mockSnippetID, `{"title": "Haiku", "content": "A frog", "language": "cobol", "expires": 7}`, http.StatusUnprocessableEntity},
+		{"Other user's snippet", mockOtherSnippetID, validBody, http.StatusForbidden},
+		{"Non-existent ID", "3fa80338-89b5-407e-b294-c3ac68238070", validBody, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveAPI(t, app.apiSnippetUpdate, http.MethodPut, tt.id, tt.body, mockUserID)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			// Check that every response is a JSON object.
+			var data map[string]any
+			assert.NilError(t, json.Unmarshal([]byte(body), &data))
+		})
+	}
+}
+
+func TestAPISnippetDelete(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{"Own snippet", mockSnippetID, http.StatusNoContent},
+		{"Other user's snippet", mockOtherSnippetID, http.StatusForbidden},
+		{"Non-existent ID", "3fa80338-89b5-407e-b294-c3ac68238070", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := serveAPI(t, app.apiSnippetDelete, http.MethodDelete, tt.id, "", mockUserID)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
@@ -3,3 +3,12 @@ package main
 type contextKey string
 
 const isAuthenticatedCOntextKey = contextKey("isAuthenticated")
+
+// Add a context key for the ID of the authenticated user.
+const authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+
+// Add a context key for the API token used to authenticate a request.
+const tokenContextKey = contextKey("token")
+
+// Add a context key for the details of a request which are logged with it.
+const requestInfoContextKey = contextKey("requestInfo")
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"html/template"
+	"mime"
 
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/diff"
+	"github.com/Avixph/learn-go-snippetbox/internal/highlight"
 	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc"
+	"github.com/Avixph/learn-go-snippetbox/internal/totp"
 	"github.com/Avixph/learn-go-snippetbox/internal/validator"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -20,20 +31,67 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	// Because httprouter matches the "/" path exactly, we don't need the manual
 	// check of `if r.URL.Path != "/"` from the handler.
 
-	snippets, err := app.snippets.Latest()
+	// Read the pagination cursors and page size from the query string, and
+	// send a 400 Bad Request response if any of them are invalid.
+	after, before, limit, err := readPageParams(r)
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Read the tags to filter by from the query string. Several tags can be
+	// given at once (ex: "?tags=sql,bash"), in which case only snippets that
+	// have all of them are shown.
+	tags := validator.NormalizeTags(r.URL.Query().Get("tags"))
+
+	page, err := app.snippets.Latest(tags, after, before, limit)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
 	// Call the newTemplateData() helper to get a templateData struct containg
 	// the 'default' data (which for now is just the current year), and add
-	// the snippet slice to it.
+	// the snippet slice and the links to the next and previous pages to it.
 	templData := app.newTemplateData(r)
-	templData.Snippets = snippets
+	templData.Snippets = page.Snippets
+	templData.NextPage, templData.PrevPage = pageURLs(r, page)
+	templData.Tags = tags
 
 	// Use the new render helper.
-	app.render(w, http.StatusOK, "home.html", templData)
+	app.render(w, r, http.StatusOK, "home.html", templData)
+}
+
+// Define a tagView handler func which lists the snippets with a specific tag.
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// Tag names are stored in lowercase, so normalize the name from the URL
+	// before checking that it's a valid tag.
+	tag := strings.ToLower(params.ByName("name"))
+	if !validator.Matches(tag, validator.TagRX) {
+		app.notFound(w)
+		return
+	}
+
+	after, before, limit, err := readPageParams(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.Latest([]string{tag}, after, before, limit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Snippets = page.Snippets
+	templData.NextPage, templData.PrevPage = pageURLs(r, page)
+	templData.Tags = []string{tag}
+
+	app.render(w, r, http.StatusOK, "tag.html", templData)
 }
 
 // Define a about handler func.
@@ -42,7 +100,7 @@ func (app *application) about(w http.ResponseWriter, r *http.Request) {
 	templData := app.newTemplateData(r)
 
 	// Call the render helper.
-	app.render(w, http.StatusOK, "about.html", templData)
+	app.render(w, r, http.StatusOK, "about.html", templData)
 }
 
 // Define a snippetView handler func
@@ -71,7 +129,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
@@ -88,11 +146,175 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	templData := app.newTemplateData(r)
 	templData.Snippet = snippet
 
+	// Highlight the snippet's content using the highlight cache. The cache key
+	// includes the time the snippet was last updated, so an edited snippet is
+	// highlighted again rather than showing stale content.
+	key := fmt.Sprintf("%s/%d", snippet.ID, snippet.UpdatedOn.UnixNano())
+	templData.Highlighted = app.highlights.Highlight(key, snippet.Language, snippet.Content)
+
 	// Pass the flash message to the template.
 	// templData.Flash = flash
 
 	// Use the new render helper.
-	app.render(w, http.StatusOK, "view.html", templData)
+	app.render(w, r, http.StatusOK, "view.html", templData)
+}
+
+// Define a snippetRaw handler func which sends the content of a snippet as
+// plain text, so that it can be fetched with tools like curl.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	// The getSnippet() helper uses SnippetModel.Get(), so expired snippets
+	// result in a 404 Not Found response just like on the view page.
+	snippet, ok := app.getSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSnippetContent(w, snippet)
+}
+
+// Define a snippetDownload handler func which sends the content of a snippet
+// as a file attachment. The filename is built from the snippet's title and
+// the file extension for its language.
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.getSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Use the mime.FormatMediaType() func to build the header value, so that
+	// the filename is quoted (and encoded if needed) correctly.
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": snippetFilename(snippet),
+	})
+	w.Header().Set("Content-Disposition", disposition)
+
+	app.writeSnippetContent(w, snippet)
+}
+
+// Define a snippetHistory handler func which lists the revisions of a
+// snippet.
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.getSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Snippet = snippet
+	templData.Revisions = revisions
+
+	app.render(w, r, http.StatusOK, "history.html", templData)
+}
+
+// Define a snippetDiff handler func which shows the changes between two
+// revisions of a snippet. The revision numbers are read from the "from" and
+// "to" query string parameters.
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.getSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Convert the revision numbers to integers, sending a 400 Bad Request
+	// response if either of them is missing or invalid.
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	fromRevision, err := app.snippets.Revision(snippet.ID, from)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	toRevision, err := app.snippets.Revision(snippet.ID, to)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Snippet = snippet
+	templData.FromRevision = fromRevision
+	templData.ToRevision = toRevision
+	// Use diff.Unified() to group the changed lines into hunks, with three
+	// lines of context around each change. Revisions with too many changed
+	// lines to compare are reported as such, rather than as an error.
+	templData.Hunks, err = diff.Unified(fromRevision.Content, toRevision.Content, 3)
+	if errors.Is(err, diff.ErrTooLarge) {
+		templData.DiffTooLarge = true
+	} else if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, http.StatusOK, "diff.html", templData)
+}
+
+// Define a searchForm struct to hold the search query and any validation
+// errors. The query is read from the URL query string rather than a POST
+// body, so that search results can be bookmarked and shared.
+type searchForm struct {
+	Query               string `form:"q"`
+	validator.Validator `form:"-"`
+}
+
+// Define a search handler func which displays the snippets matching the
+// "q" query string parameter.
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	form := searchForm{
+		Query: strings.TrimSpace(r.URL.Query().Get("q")),
+	}
+
+	templData := app.newTemplateData(r)
+
+	// If there's no query yet, just display the empty search form.
+	if form.Query == "" {
+		templData.Form = form
+		app.render(w, r, http.StatusOK, "search.html", templData)
+		return
+	}
+
+	form.CheckField(validator.MaxChars(form.Query, 200), "q", "This field cannot be more than 200 characters long!")
+
+	if !form.Valid() {
+		templData.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "search.html", templData)
+		return
+	}
+
+	results, err := app.snippets.Search(form.Query, 50)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData.Form = form
+	templData.SearchResults = results
+
+	app.render(w, r, http.StatusOK, "search.html", templData)
 }
 
 // Define snippetCreateForm handler func, which for now returns a placeholder.
@@ -104,10 +326,11 @@ func (app *application) snippetCreateForm(w http.ResponseWriter, r *http.Request
 	// 'initial' values for the form --- here we set the initial value for the
 	// snippet expiry to 365 days.
 	templData.Form = snippetForm{
-		Expires: 365,
+		Language: "plaintext",
+		Expires:  365,
 	}
 
-	app.render(w, http.StatusOK, "create.html", templData)
+	app.render(w, r, http.StatusOK, "create.html", templData)
 }
 
 // Define a snippetForm struct to represent the form data and validation errors
@@ -123,13 +346,51 @@ func (app *application) snippetCreateForm(w http.ResponseWriter, r *http.Request
 // the value from the HTML form inputs with the name "title" in the Title
 // field. The struct tag `form:"-"` tells the decoder to completely ignore a
 // field during decoding.)
+// The Tags field holds the tags as typed by the user, while the TagList
+// field holds the normalized tags and is filled in by the validate() method.
+// ExpiresOn holds the current expiry of a snippet being edited. When it's
+// set, an Expires value of 0 keeps that expiry instead of starting a new one.
 type snippetForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"`
+	Title               string    `form:"title"`
+	Content             string    `form:"content"`
+	Language            string    `form:"language"`
+	Tags                string    `form:"tags"`
+	TagList             []string  `form:"-"`
+	Expires             int       `form:"expires"`
+	ExpiresOn           time.Time `form:"-"`
 	validator.Validator `form:"-"`
 }
 
+// Define the longest snippet content we accept, in characters. This keeps
+// the work done to highlight and compare snippets bounded.
+const snippetContentMaxChars = 50000
+
+// The validate() method runs the validation checks for the snippetForm. It's
+// shared by the snippetCreate and snippetEdit handlers so that the same rules
+// apply whenever a snippet is created or changed.
+func (form *snippetForm) validate() {
+	// Since the validator type is embedded by the snippetForm struct, we can call
+	// CheckField() directly on iy to execute our validation checks. CheckField() will
+	// add the provided key and error message to the FieldErrors map if the check does
+	// not evaluate to true.
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank!")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long!")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank!")
+	form.CheckField(validator.MaxChars(form.Content, snippetContentMaxChars), "content", fmt.Sprintf("This field cannot be more than %d characters long!", snippetContentMaxChars))
+	if form.ExpiresOn.IsZero() {
+		form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365!")
+	} else {
+		form.CheckField(validator.PermittedValue(form.Expires, 0, 1, 7, 365), "expires", "This field must equal 0, 1, 7 or 365!")
+	}
+	form.CheckField(validator.PermittedValue(form.Language, highlight.Names()...), "language", "This field must be one of the listed languages!")
+
+	// Normalize the tags before checking them, so that "SQL, bash" and
+	// "sql bash" are treated the same.
+	form.TagList = validator.NormalizeTags(form.Tags)
+	form.CheckField(validator.MaxItems(form.TagList, 5), "tags", "This field cannot contain more than 5 tags!")
+	form.CheckField(validator.AllMatch(form.TagList, validator.TagRX), "tags", "Each tag must be up to 32 letters, digits or + # . _ - characters!")
+}
+
 // Define snippetCreate handler func
 // Change the signature if the snippetCreate handler so it is defined as a
 // method against *application.
@@ -166,32 +427,30 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	// 	Expires: expireVal,
 	// }
 
-	// Since the validator type is embedded by the snippetForm struct, we can call
-	// CheckField() directly on iy to execute our validation checks. CheckField() will
-	// add the provided key and error message to the FieldErrors map if the check does
-	// not evaluate to true.
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank!")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long!")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank!")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365!")
+	// Call the validate() method to execute our validation checks against the
+	// form data.
+	form.validate()
 
 	// Use the Valid() method to see if any of the check failed. If they did,
 	// then re-render the template passing in the form in the same way as before.
 	if !form.Valid() {
 		templData := app.newTemplateData(r)
 		templData.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.html", templData)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", templData)
 		return
 	}
 
-	// Pass the data to the SnippetModel.Insert() method, receive the ID of
-	// the new record back.
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	// Pass the data to the SnippetModel.Insert() method, along with the ID of
+	// the authenticated user so that they are recorded as the snippet's
+	// author, and receive the ID of the new record back.
+	id, err := app.snippets.Insert(app.authenticatedUserID(r), form.Title, form.Content, form.Language, form.TagList, form.Expires)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
+	app.metrics.snippetsCreated.Inc()
+
 	// Use the put() method to add a string value ("Snippet successfully created!") and the corresponding key ("flash") to the session data.
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 
@@ -201,6 +460,96 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%v", id), http.StatusSeeOther)
 }
 
+// Define a snippetEditForm handler func which displays the edit form for a
+// snippet, pre-populated with the snippet's current data.
+func (app *application) snippetEditForm(w http.ResponseWriter, r *http.Request) {
+	// Use the ownedSnippet() helper to retrieve the snippet and check that it
+	// belongs to the authenticated user.
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Snippet = snippet
+	// Keep the snippet's current expiry unless the user picks a new one.
+	templData.Form = snippetForm{
+		Title:     snippet.Title,
+		Content:   snippet.Content,
+		Language:  snippet.Language,
+		Tags:      strings.Join(snippet.Tags, ", "),
+		ExpiresOn: snippet.ExpiresOn,
+	}
+
+	app.render(w, r, http.StatusOK, "edit.html", templData)
+}
+
+// Define a snippetEdit handler func which updates a snippet.
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	form := snippetForm{ExpiresOn: snippet.ExpiresOn}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Use the same validation rules as the snippetCreate handler, and
+	// re-display the edit form if any of them fail.
+	form.validate()
+
+	if !form.Valid() {
+		templData := app.newTemplateData(r)
+		templData.Snippet = snippet
+		templData.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.html", templData)
+		return
+	}
+
+	err = app.snippets.Update(snippet.ID, app.authenticatedUserID(r), form.Title, form.Content, form.Language, form.TagList, form.Expires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%v", snippet.ID), http.StatusSeeOther)
+}
+
+// Define a snippetDelete handler func which removes a snippet.
+func (app *application) snippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(snippet.ID, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully deleted!")
+
+	// Redirect the user to their account page, which lists their remaining
+	// snippets.
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
 // Create a new signForm struct
 type signupForm struct {
 	Name                string `form:"name"`
@@ -214,7 +563,7 @@ func (app *application) userSignupForm(w http.ResponseWriter, r *http.Request) {
 	templData := app.newTemplateData(r)
 	templData.Form = signupForm{}
 
-	app.render(w, http.StatusOK, "signup.html", templData)
+	app.render(w, r, http.StatusOK, "signup.html", templData)
 }
 
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
@@ -232,15 +581,18 @@ func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
 	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
 	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 16), "password", "This field must be at least 16 characters long")
+	err = app.passwordPolicy.CheckPassword(&form.Validator, "password", form.Password, form.Name, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
 	// If there are any errors, redisplay the signup form along with
 	// a 422 status code.
 	if !form.Valid() {
 		templData := app.newTemplateData(r)
 		templData.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.html", templData)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", templData)
 		return
 	}
 
@@ -253,16 +605,25 @@ func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 
 			templData := app.newTemplateData(r)
 			templData.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.html", templData)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", templData)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 
 		return
 	}
 
+	app.metrics.signups.Inc()
+
+	// Send the new user a link to verify their email address.
+	err = app.sendVerificationEmail(form.Name, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// Else add a confirmation flash message to the session, confirming that their signup worked.
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please check your email to verify your address, and log in.")
 
 	// Redirect the user to the login page.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -280,7 +641,7 @@ func (app *application) userLoginForm(w http.ResponseWriter, r *http.Request) {
 	templData := app.newTemplateData(r)
 	templData.Form = loginForm{}
 
-	app.render(w, http.StatusOK, "login.html", templData)
+	app.render(w, r, http.StatusOK, "login.html", templData)
 }
 
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
@@ -305,39 +666,126 @@ func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 		templData := app.newTemplateData(r)
 		templData.Form = form
 
-		app.render(w, http.StatusUnprocessableEntity, "login.html", templData)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", templData)
+		return
+	}
+
+	// Count the attempt, and refuse to check the credentials if there have
+	// been too many failed logins for the account, or from the client's IP
+	// address. Doing this first means that a locked out client doesn't cost
+	// us a bcrypt hash.
+	locked, err := app.loginAttempt(r, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if locked > 0 {
+		form.AddNonFieldError(lockoutMessage(locked))
+		app.renderLocked(w, r, "login.html", form, locked)
 		return
 	}
 
-	// Check whether the credentials are valid. If they're not, add a generic
-	// non-field error message and re-display the login page.
+	// Check whether the credentials are valid. If they're not, record the
+	// failure, add a generic non-field error message and re-display the
+	// login page.
 	id, err := app.users.Authenticate(form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			locked, err := app.loginFailed(r, form.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if locked > 0 {
+				form.AddNonFieldError(lockoutMessage(locked))
+				app.renderLocked(w, r, "login.html", form, locked)
+				return
+			}
+
 			form.AddNonFieldError("Email or password is incorrect")
 
 			templData := app.newTemplateData(r)
 			templData.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.html", templData)
+			app.render(w, r, http.StatusUnprocessableEntity, "login.html", templData)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	// If the user has enabled two-factor authentication, the password alone
+	// isn't enough to log in. Instead we remember who they are in the session
+	// and ask for an authentication code as a second step.
+	_, err = app.twoFactor.Get(uuid.MustParse(id))
+	if err == nil {
+		err = app.loginPasswordAccepted(form.Email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.startTwoFactorLogin(w, r, id)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.loginSucceeded(form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, id)
+}
+
+// The renderLocked helper re-displays a form page (with the lockout error
+// already added to the form) using a 429 Too Many Requests status, and
+// a Retry-After header saying when to try again.
+func (app *application) renderLocked(w http.ResponseWriter, r *http.Request, page string, form any, locked time.Duration) {
+	setRetryAfter(w, locked)
+
+	templData := app.newTemplateData(r)
+	templData.Form = form
+	app.render(w, r, http.StatusTooManyRequests, page, templData)
+}
+
+// The startTwoFactorLogin helper remembers which user is logging in, and
+// sends them to the page which asks for their authentication code.
+func (app *application) startTwoFactorLogin(w http.ResponseWriter, r *http.Request, id string) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+	app.sessionManager.Put(r.Context(), "twoFactorStartedOn", app.clock().Unix())
+
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// The completeLogin helper logs the user with the given ID in, and redirects
+// them to the page they were trying to access (or the create snippet page).
+// It's called once the user has passed every login step.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, id string) {
 	// Use the RenewToken() method on the curent session to change the session
 	// ID. It's good practice to generate a new session ID when the authentication
 	// state or privilage levels changes for the user (ex: login and logout
 	// operations).
-	err = app.sessionManager.RenewToken(r.Context())
+	err := app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now
-	// 'logged in'.
+	// Remove any half-finished two-factor login, and add the ID of the
+	// current user to the session, so that they are now 'logged in'.
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorStartedOn")
 	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.recordSessionStart(r)
 
 	// Use the PopString method to retrieve and remove the "redirectPathAfterLogin"
 	// value from the session data. If no matching key exists then return an empty
@@ -345,72 +793,1251 @@ func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	path := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
 	if path != "" {
 		http.Redirect(w, r, path, http.StatusSeeOther)
+		return
 	}
 	// Redirect the user to the create snippet page.
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
-
 }
 
-func (app *application) userLogout(w http.ResponseWriter, r *http.Request) {
-	// Use the RenewToken() method on the current session to change the
-	// session ID.
-	err := app.sessionManager.RenewToken(r.Context())
+// Define how long a user has to enter their authentication code after
+// entering their password.
+const twoFactorLoginTTL = 5 * time.Minute
+
+// The twoFactorLoginUser helper returns the ID of the user who is part way
+// through logging in with two-factor authentication. If there isn't one, or
+// they took too long to enter their code, the returned bool is false.
+func (app *application) twoFactorLoginUser(r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(app.sessionManager.GetString(r.Context(), "twoFactorUserID"))
 	if err != nil {
-		app.serverError(w, err)
-		return
+		return uuid.Nil, false
 	}
 
-	// Remove the authenticatedUserID from the session data so that the
-	// user is 'logged out'.
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-
-	// Add a flash message to the session to confirm to the user that they've
-	// benn logged out.
-	app.sessionManager.Put(r.Context(), "flash", "You've been successfully logged out!")
+	startedOn := time.Unix(app.sessionManager.GetInt64(r.Context(), "twoFactorStartedOn"), 0)
+	if app.clock().Sub(startedOn) > twoFactorLoginTTL {
+		return uuid.Nil, false
+	}
 
-	// Redirect the user to the app homepage.
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return id, true
 }
 
-func ping(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("OK"))
-}
+// The checkTwoFactorCode helper checks a code entered by a user with
+// two-factor authentication enabled. The code can either be the current code
+// from their authenticator app, or one of their recovery codes. Both kinds
+// of code can only be used once. The first returned bool reports whether a
+// recovery code was used, and the second whether the code was valid.
+func (app *application) checkTwoFactorCode(tf *models.TwoFactor, code string) (bool, bool, error) {
+	code = strings.TrimSpace(code)
+
+	// Codes from authenticator apps are all digits, whereas recovery codes
+	// always contain letters.
+	if _, err := strconv.Atoi(strings.ReplaceAll(code, " ", "")); err == nil {
+		step, ok := totp.Validate(tf.Secret, code, app.clock(), 1)
+		if !ok {
+			return false, false, nil
+		}
 
-func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
-	id := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+		err = app.twoFactor.UseStep(tf.UserID, step)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidToken) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+
+		return false, true, nil
+	}
 
-	user, err := app.users.Get(uuid.MustParse(id))
+	err := app.twoFactor.UseRecoveryCode(tf.UserID, code)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-		} else {
-			app.serverError(w, err)
+		if errors.Is(err, models.ErrInvalidToken) {
+			return true, false, nil
 		}
-		return
+		return true, false, err
 	}
 
-	// fmt.Fprintf(w, "%+v", user)
+	return true, true, nil
+}
+
+// Create a twoFactorForm struct to represent the forms which ask for a
+// two-factor authentication code.
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// Define a userLoginTwoFactorForm handler func which displays the second
+// login step, asking for an authentication code.
+func (app *application) userLoginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.twoFactorLoginUser(r); !ok {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Form = twoFactorForm{}
+
+	app.render(w, r, http.StatusOK, "login2fa.html", templData)
+}
+
+// Define a userLoginTwoFactor handler func which checks the authentication
+// code entered in the second login step, and logs the user in if it's
+// valid.
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.twoFactorLoginUser(r)
+	if !ok {
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		templData := app.newTemplateData(r)
+		templData.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login2fa.html", templData)
+		return
+	}
+
+	// Failed authentication codes count towards the same lockout as failed
+	// passwords, otherwise the codes could be guessed by brute force.
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	locked, err := app.loginAttempt(r, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if locked > 0 {
+		form.AddNonFieldError(lockoutMessage(locked))
+		app.renderLocked(w, r, "login2fa.html", form, locked)
+		return
+	}
+
+	tf, err := app.twoFactor.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	recovery, ok, err := app.checkTwoFactorCode(tf, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !ok {
+		locked, err := app.loginFailed(r, user.Email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if locked > 0 {
+			form.AddNonFieldError(lockoutMessage(locked))
+			app.renderLocked(w, r, "login2fa.html", form, locked)
+			return
+		}
+
+		form.AddNonFieldError("Authentication code is incorrect")
+
+		templData := app.newTemplateData(r)
+		templData.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login2fa.html", templData)
+		return
+	}
+
+	err = app.loginSucceeded(user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Warn users who log in with a recovery code how many they have left.
+	if recovery {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You logged in with a recovery code. You have %d recovery codes left.", tf.RecoveryCodesLeft-1))
+	}
+
+	app.completeLogin(w, r, id.String())
+}
+
+// Define how long a user has to log in at their identity provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// Define an oidcLogin handler func which starts a single sign-on login.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProvider(httprouter.ParamsFromContext(r.Context()).ByName("provider"))
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	// Make sure a re-authentication that was abandoned part way through
+	// can't be finished by this login.
+	app.sessionManager.Remove(r.Context(), "oidcReauth")
+	app.sessionManager.Remove(r.Context(), "oidcReauthEmail")
+
+	app.redirectToProvider(w, r, provider, false)
+}
+
+// The redirectToProvider helper stores a random state, nonce and PKCE code
+// verifier in the session, and sends the user to the identity provider's
+// login page. If reauth is true the provider is asked to make the user log
+// in again, even if they're already logged in there.
+func (app *application) redirectToProvider(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, reauth bool) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	authCodeURL := provider.AuthCodeURL
+	if reauth {
+		authCodeURL = provider.ReauthURL
+	}
+
+	authURL, err := authCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "oidcProvider", provider.Name)
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+	app.sessionManager.Put(r.Context(), "oidcStartedOn", app.clock().Unix())
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// Define an oidcCallback handler func which finishes a single sign-on login
+// when the identity provider sends the user back. The state must match the
+// one in the session, which stops other sites from logging the user in to
+// an account of their choosing. The ID token is then fetched and verified,
+// and the identity is linked to a user. Users who have enabled two-factor
+// authentication still need to enter a code. If the user was sent to the
+// provider to confirm an account change, the change is made instead.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	providerName := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+
+	provider, ok := app.oidcProvider(providerName)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	// Use the values from the session once only, whatever happens.
+	ctx := r.Context()
+	sessionProvider := app.sessionManager.PopString(ctx, "oidcProvider")
+	state := app.sessionManager.PopString(ctx, "oidcState")
+	nonce := app.sessionManager.PopString(ctx, "oidcNonce")
+	verifier := app.sessionManager.PopString(ctx, "oidcVerifier")
+	startedOn := app.sessionTime(ctx, "oidcStartedOn")
+	app.sessionManager.Remove(ctx, "oidcStartedOn")
+	reauth := app.sessionManager.PopString(ctx, "oidcReauth")
+	reauthEmail := app.sessionManager.PopString(ctx, "oidcReauthEmail")
+
+	failed := func(message string) {
+		app.sessionManager.Put(ctx, "flash", message)
+		if reauth != "" {
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case state == "" || sessionProvider != provider.Name:
+		failed("Your single sign-on login failed. Please try again.")
+		return
+	case subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1:
+		failed("Your single sign-on login failed. Please try again.")
+		return
+	case app.clock().Sub(startedOn) > oidcLoginTTL:
+		failed("Your single sign-on login took too long. Please try again.")
+		return
+	case query.Get("error") != "" || query.Get("code") == "":
+		failed("Your single sign-on login failed. Please try again.")
+		return
+	}
+
+	claims, err := provider.Exchange(ctx, query.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) || errors.Is(err, oidc.ErrExchangeFailed) {
+			app.logger.Warn("single sign-on failed", append(app.requestAttrs(r), "provider", provider.Name, "error", err)...)
+			failed("Your single sign-on login failed. Please try again.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if reauth != "" {
+		app.finishReauth(w, r, provider, claims, startedOn, reauth, reauthEmail)
+		return
+	}
+
+	id, err := app.users.LinkIdentity(provider.Name, claims.Subject, claims.Email, claims.Name, claims.EmailVerified)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnverifiedEmail):
+			failed("Your email address needs to be verified before you can use single sign-on. Please log in with your password and verify it first.")
+		case errors.Is(err, models.ErrDuplicateEmail):
+			failed("Your single sign-on login failed. Please try again.")
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	_, err = app.twoFactor.Get(id)
+	if err == nil {
+		app.startTwoFactorLogin(w, r, id.String())
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, id.String())
+}
+
+// Define the account changes which users without a password confirm by
+// logging in with their identity provider again.
+const (
+	reauthAccountDelete = "delete"
+	reauthEmailChange   = "email"
+)
+
+// The startReauth helper sends the authenticated user to their identity
+// provider to log in again, and records which account change to make when
+// they come back. For an email change, email is the new address.
+func (app *application) startReauth(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, action, email string) {
+	app.sessionManager.Put(r.Context(), "oidcReauth", action)
+	app.sessionManager.Put(r.Context(), "oidcReauthEmail", email)
+
+	app.redirectToProvider(w, r, provider, true)
+}
+
+// The finishReauth helper makes the account change recorded by startReauth,
+// once the identity provider has sent the user back. The identity has to be
+// one which is linked to the logged in user, and the provider has to say
+// that they logged in after they were sent there, rather than relying on an
+// earlier login at the provider.
+func (app *application) finishReauth(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, claims *oidc.Claims, startedOn time.Time, action, email string) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	identities, err := app.users.Identities(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	linked := false
+	for _, identity := range identities {
+		if identity.Provider == provider.Name && subtle.ConstantTimeCompare([]byte(identity.Subject), []byte(claims.Subject)) == 1 {
+			linked = true
+			break
+		}
+	}
+
+	if !linked || !claims.AuthenticatedSince(startedOn) {
+		app.logger.Warn("single sign-on re-authentication failed", append(app.requestAttrs(r), "provider", provider.Name)...)
+		app.sessionManager.Put(r.Context(), "flash", "We couldn't confirm that it's you. Please try again.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	switch action {
+	case reauthAccountDelete:
+		app.deleteAccount(w, r, user.ID)
+	case reauthEmailChange:
+		err = app.requestEmailChange(r, user, email)
+		if err != nil {
+			if errors.Is(err, models.ErrDuplicateEmail) {
+				app.sessionManager.Put(r.Context(), "flash", "That email address is already in use.")
+				http.Redirect(w, r, "/account/email/update", http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We've sent a confirmation link to %s. Your email address will change once you open it.", email))
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+	default:
+		app.clientError(w, http.StatusBadRequest)
+	}
+}
+
+func (app *application) userLogout(w http.ResponseWriter, r *http.Request) {
+	// Use the RenewToken() method on the current session to change the
+	// session ID.
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Remove the authenticatedUserID from the session data so that the
+	// user is 'logged out'.
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+
+	// Add a flash message to the session to confirm to the user that they've
+	// benn logged out.
+	app.sessionManager.Put(r.Context(), "flash", "You've been successfully logged out!")
+
+	// Redirect the user to the app homepage.
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func ping(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	templData, ok := app.accountTemplateData(w, r)
+	if !ok {
+		return
+	}
+	templData.Form = tokenForm{Scope: models.ScopeRead}
+
+	// Call the render helper.
+	app.render(w, r, http.StatusOK, "account.html", templData)
+}
+
+// The accountUser helper retrieves the authenticated user's details. If the
+// user no longer exists they are redirected to the login page and the
+// returned bool is false.
+func (app *application) accountUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// The accountTemplateData helper gathers the data shown on the account page:
+// the user's details, their snippets and their API tokens. It's shared by the
+// handlers which re-display the account page. If the user no longer exists
+// they are redirected to the login page and the returned bool is false.
+func (app *application) accountTemplateData(w http.ResponseWriter, r *http.Request) (*templateData, bool) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	id := user.ID
+
+	// fmt.Fprintf(w, "%+v", user)
+
+	// Retrieve the snippets created by the user so that we can list them on
+	// their account page.
+	snippets, err := app.snippets.ByAuthor(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	// Retrieve the user's personal API tokens. Only their names and metadata
+	// are available here, since the tokens themselves are stored hashed.
+	tokens, err := app.tokens.ForUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	// Retrieve the user's two-factor settings, which are nil if they haven't
+	// enabled two-factor authentication.
+	tf, err := app.twoFactor.Get(id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	// Call the newTemplateData() helper.
+	templData := app.newTemplateData(r)
+	templData.User = user
+	templData.Snippets = snippets
+	templData.Tokens = tokens
+	templData.TwoFactor = tf
+
+	return templData, true
+}
+
+// Define how long email verification links stay valid for.
+const emailVerificationTTL = 24 * time.Hour
+
+// The sendVerificationEmail helper creates a verification token for the given
+// email address, and emails the user a link to verify it.
+func (app *application) sendVerificationEmail(name, email string) error {
+	token, err := app.verifications.New(email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"Name":    name,
+		"URL":     fmt.Sprintf("%s/user/verify/%s", app.baseURL, token),
+		"Expires": "24 hours",
+	}
+
+	return app.sendEmail(email, "verify_email.tmpl", data)
+}
+
+// Define an accountVerifyResend handler func which sends the authenticated
+// user a new link to verify their email address.
+func (app *application) accountVerifyResend(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.EmailVerified() {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	err := app.sendVerificationEmail(user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We've sent a new verification link to %s.", user.Email))
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Define a userVerifyEmail handler func which verifies the email address of
+// the user that the token in the URL was sent to. The user doesn't need to
+// be logged in, since they may open the link on a different device.
+func (app *application) userVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	// Send authenticated users back to their account page, and everybody else
+	// to the login page.
+	next := "/user/login"
+	if app.isAuthenticated(r) {
+		next = "/account/view"
+	}
+
+	_, err := app.verifications.Verify(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "That verification link is invalid or has expired.")
+			http.Redirect(w, r, next, http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified!")
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Create a nameUpdateForm struct to represent the form for changing the
+// authenticated user's display name.
+type nameUpdateForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+// Define an accountUpdateForm handler func which displays the form for
+// changing the authenticated user's display name.
+func (app *application) accountUpdateForm(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Form = nameUpdateForm{Name: user.Name}
+
+	app.render(w, r, http.StatusOK, "update.html", templData)
+}
+
+// Define an accountUpdate handler func which changes the authenticated
+// user's display name, and records the change in their audit trail.
+func (app *application) accountUpdate(w http.ResponseWriter, r *http.Request) {
+	var form nameUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters long")
+
+	if !form.Valid() {
+		templData := app.newTemplateData(r)
+		templData.Form = form
+
+		app.render(w, r, http.StatusUnprocessableEntity, "update.html", templData)
+		return
+	}
+
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	// There's nothing to do (or record) if the name hasn't changed.
+	if form.Name != user.Name {
+		err = app.users.UpdateName(user.ID, form.Name)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		err = app.recordAudit(r, user.ID, models.AuditNameChanged, fmt.Sprintf("Changed name from %q to %q", user.Name, form.Name))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your name has been updated!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Define how long email change links stay valid for.
+const emailChangeTTL = time.Hour
+
+// Create an emailUpdateForm struct to represent the form for changing the
+// authenticated user's email address. The user has to enter their password
+// too, so that somebody using an unattended session can't take over the
+// account. Users who haven't set a password (see reauthOptions) confirm
+// the change by logging in with their identity provider again instead.
+type emailUpdateForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	reauthOptions       `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+// Define an accountEmailUpdateForm handler func which displays the form for
+// changing the authenticated user's email address.
+func (app *application) accountEmailUpdateForm(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	form := emailUpdateForm{}
+
+	err := app.setReauthOptions(&form.reauthOptions, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Form = form
+
+	app.render(w, r, http.StatusOK, "email.html", templData)
+}
+
+// Define an accountEmailUpdate handler func which emails a link to the new
+// address. The user's email address isn't changed until they open it.
+func (app *application) accountEmailUpdate(w http.ResponseWriter, r *http.Request) {
+	var form emailUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.setReauthOptions(&form.reauthOptions, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(form.Email != user.Email, "email", "This is already your email address")
+	if !form.NoPassword {
+		form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	}
+
+	if !form.Valid() || (form.NoPassword && form.ReauthProvider == nil) {
+		templData := app.newTemplateData(r)
+		templData.Form = form
+
+		app.render(w, r, http.StatusUnprocessableEntity, "email.html", templData)
+		return
+	}
+
+	if form.NoPassword {
+		app.startReauth(w, r, form.ReauthProvider, reauthEmailChange, form.Email)
+		return
+	}
+
+	// Limit the number of passwords which can be tried, so that somebody
+	// with access to the session can't guess the password by brute force
+	// and move the account to their own email address.
+	locked, err := app.reauthAttempt(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if locked > 0 {
+		form.AddFieldError("password", reauthLockoutMessage(locked))
+		app.renderLocked(w, r, "email.html", form, locked)
+		return
+	}
+
+	_, err = app.users.Authenticate(user.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			locked, err := app.reauthFailed(user.ID)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if locked > 0 {
+				form.AddFieldError("password", reauthLockoutMessage(locked))
+				app.renderLocked(w, r, "email.html", form, locked)
+				return
+			}
+
+			form.AddFieldError("password", "Password is incorrect")
+
+			templData := app.newTemplateData(r)
+			templData.Form = form
+
+			app.render(w, r, http.StatusUnprocessableEntity, "email.html", templData)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.reauthSucceeded(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.requestEmailChange(r, user, form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
+
+			templData := app.newTemplateData(r)
+			templData.Form = form
+
+			app.render(w, r, http.StatusUnprocessableEntity, "email.html", templData)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We've sent a confirmation link to %s. Your email address will change once you open it.", form.Email))
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// The requestEmailChange helper emails a link for changing the user's email
+// address to the new address, and records the request in their audit
+// trail. If the new address is already used by another user the
+// ErrDuplicateEmail error is returned.
+func (app *application) requestEmailChange(r *http.Request, user *models.User, email string) error {
+	token, err := app.verifications.NewEmailChange(user.ID, email, emailChangeTTL)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"Name":    user.Name,
+		"Email":   email,
+		"URL":     fmt.Sprintf("%s/user/email/confirm/%s", app.baseURL, token),
+		"Expires": "1 hour",
+	}
+
+	err = app.sendEmail(email, "email_change.tmpl", data)
+	if err != nil {
+		return err
+	}
+
+	return app.recordAudit(r, user.ID, models.AuditEmailChangeRequested, fmt.Sprintf("Requested a change of email address to %s", email))
+}
+
+// Define a userEmailConfirm handler func which changes a user's email
+// address to the one that the token in the URL was sent to. Like
+// userVerifyEmail, the user doesn't need to be logged in. A notice is sent
+// to the old address, so that the owner finds out if somebody else made the
+// change.
+func (app *application) userEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	next := "/user/login"
+	if app.isAuthenticated(r) {
+		next = "/account/view"
+	}
+
+	change, err := app.verifications.ConfirmEmailChange(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidToken):
+			app.sessionManager.Put(r.Context(), "flash", "That confirmation link is invalid or has expired.")
+			http.Redirect(w, r, next, http.StatusSeeOther)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), "flash", "That email address is already in use.")
+			http.Redirect(w, r, next, http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, change.UserID, models.AuditEmailChanged, fmt.Sprintf("Changed email address from %s to %s", change.OldEmail, change.NewEmail))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	user, err := app.users.Get(change.UserID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := map[string]any{
+		"Name":     user.Name,
+		"OldEmail": change.OldEmail,
+		"NewEmail": change.NewEmail,
+	}
+
+	err = app.sendEmail(change.OldEmail, "email_changed.tmpl", data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed!")
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Define how many audit trail events are shown on the activity page.
+const auditEventsLimit = 50
+
+// Define an accountActivity handler func which lists the most recent events
+// in the authenticated user's audit trail.
+func (app *application) accountActivity(w http.ResponseWriter, r *http.Request) {
+	events, err := app.auditLog.ForUser(app.authenticatedUserID(r), auditEventsLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.AuditEvents = events
+
+	app.render(w, r, http.StatusOK, "activity.html", templData)
+}
+
+// Create a tokenForm struct to represent the form for creating a personal API
+// token.
+type tokenForm struct {
+	Name                string `form:"name"`
+	Scope               string `form:"scope"`
+	validator.Validator `form:"-"`
+}
+
+// Define a tokenCreate handler func which creates a new personal API token
+// for the authenticated user. The plain-text token is shown once, on the
+// account page rendered in response, and is never stored.
+func (app *application) tokenCreate(w http.ResponseWriter, r *http.Request) {
+	var form tokenForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank!")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long!")
+	form.CheckField(validator.PermittedValue(form.Scope, models.ScopeRead, models.ScopeWrite), "scope", "This field must equal read or write!")
+
+	if !form.Valid() {
+		templData, ok := app.accountTemplateData(w, r)
+		if !ok {
+			return
+		}
+		templData.Form = form
+
+		app.render(w, r, http.StatusUnprocessableEntity, "account.html", templData)
+		return
+	}
+
+	token, err := app.tokens.New(app.authenticatedUserID(r), form.Name, form.Scope)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Render the account page directly, rather than redirecting, so that the
+	// plain-text token never needs to be stored in the session.
+	templData, ok := app.accountTemplateData(w, r)
+	if !ok {
+		return
+	}
+	templData.Form = tokenForm{Scope: models.ScopeRead}
+	templData.NewToken = token
+
+	app.render(w, r, http.StatusOK, "account.html", templData)
+}
+
+// Define a tokenRevoke handler func which deletes one of the authenticated
+// user's personal API tokens.
+func (app *application) tokenRevoke(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+
+	err = app.tokens.Revoke(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Token successfully revoked!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Define an accountSessions handler func which lists the sessions that the
+// user is logged in with, so that they can log out of any they don't
+// recognise.
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.userSessions(r.Context(), app.authenticatedUserID(r), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Sessions = sessions
+
+	app.render(w, r, http.StatusOK, "sessions.html", templData)
+}
+
+// Define an accountSessionRevoke handler func which logs the user out of the
+// session with the ID given in the URL. If that's the current session, this
+// is the same as logging out normally.
+func (app *application) accountSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+
+	if id == sessionID(app.sessionManager.Token(r.Context())) {
+		app.userLogout(w, r)
+		return
+	}
+
+	err := app.destroyUserSession(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The session has been logged out!")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// Define an accountSessionRevokeOthers handler func which logs the user out
+// of every session except the current one.
+func (app *application) accountSessionRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	err := app.destroyUserSessions(r.Context(), app.authenticatedUserID(r), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out everywhere else!")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// Define the text which users have to type to confirm that they want to
+// delete their account.
+const accountDeleteConfirmation = "DELETE"
+
+// Create a deleteAccountForm struct to represent the form for deleting an
+// account. As well as their password, the user has to type "DELETE" so that
+// an account can't be deleted by accident. Users who haven't set a password
+// confirm by logging in with their identity provider again instead.
+type deleteAccountForm struct {
+	Password            string `form:"password"`
+	Confirmation        string `form:"confirmation"`
+	reauthOptions       `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+// Define an accountDeleteForm handler func which displays the form for
+// deleting the authenticated user's account.
+func (app *application) accountDeleteForm(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	form := deleteAccountForm{}
+
+	err := app.setReauthOptions(&form.reauthOptions, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Form = form
+	templData.SnippetPolicy = app.snippetPolicy
+
+	app.render(w, r, http.StatusOK, "delete.html", templData)
+}
+
+// Define an accountDelete handler func which deletes the authenticated user's
+// account, and their snippets or just their name on them depending on the
+// snippet policy. The user is then logged out of every session.
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	var form deleteAccountForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.setReauthOptions(&form.reauthOptions, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.NoPassword {
+		form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	}
+	form.CheckField(form.Confirmation == accountDeleteConfirmation, "confirmation", fmt.Sprintf("Please type %s to confirm", accountDeleteConfirmation))
+
+	if !form.Valid() || (form.NoPassword && form.ReauthProvider == nil) {
+		templData := app.newTemplateData(r)
+		templData.Form = form
+		templData.SnippetPolicy = app.snippetPolicy
+
+		app.render(w, r, http.StatusUnprocessableEntity, "delete.html", templData)
+		return
+	}
+
+	if form.NoPassword {
+		app.startReauth(w, r, form.ReauthProvider, reauthAccountDelete, "")
+		return
+	}
+
+	// Limit the number of passwords which can be tried, so that somebody
+	// with access to the session can't guess the password by brute force.
+	locked, err := app.reauthAttempt(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if locked > 0 {
+		form.AddFieldError("password", reauthLockoutMessage(locked))
+		app.renderAccountDeleteLocked(w, r, form, locked)
+		return
+	}
+
+	err = app.users.CheckPassword(user.ID, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			locked, err := app.reauthFailed(user.ID)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if locked > 0 {
+				form.AddFieldError("password", reauthLockoutMessage(locked))
+				app.renderAccountDeleteLocked(w, r, form, locked)
+				return
+			}
+
+			form.AddFieldError("password", "Password is incorrect")
+
+			templData := app.newTemplateData(r)
+			templData.Form = form
+			templData.SnippetPolicy = app.snippetPolicy
+
+			app.render(w, r, http.StatusUnprocessableEntity, "delete.html", templData)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.deleteAccount(w, r, user.ID)
+}
+
+// The deleteAccount helper deletes the user's account once they've
+// confirmed that it's them, then logs them out of every session and
+// redirects to the home page.
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	err := app.users.Delete(userID, app.snippetPolicy)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Log the user out of their other sessions, then destroy the current
+	// session too. Putting the flash message afterwards starts a new,
+	// anonymous session.
+	err = app.destroyUserSessions(r.Context(), userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.sessionManager.Destroy(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted!")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// The renderAccountDeleteLocked helper re-displays the delete account form
+// (with the lockout error already added) using a 429 Too Many Requests
+// status.
+func (app *application) renderAccountDeleteLocked(w http.ResponseWriter, r *http.Request, form deleteAccountForm, locked time.Duration) {
+	setRetryAfter(w, locked)
+
+	templData := app.newTemplateData(r)
+	templData.Form = form
+	templData.SnippetPolicy = app.snippetPolicy
+	app.render(w, r, http.StatusTooManyRequests, "delete.html", templData)
+}
+
+// Define an accountExport handler func which sends the authenticated user a
+// ZIP file containing their profile and all of their snippets. The ZIP file
+// is built in memory first, so that we can still send an error response if
+// something goes wrong.
+func (app *application) accountExport(w http.ResponseWriter, r *http.Request) {
+	export, err := app.users.Export(app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
 
-	// Call the newTemplateData() helper.
-	templData := app.newTemplateData(r)
-	templData.User = user
+	now := app.clock()
 
-	// Call the render helper.
-	app.render(w, http.StatusOK, "account.html", templData)
+	var buf bytes.Buffer
+
+	err = writeAccountExport(&buf, export, now)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("snippetbox-export-%s.zip", now.UTC().Format("2006-01-02")),
+	})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", disposition)
+
+	w.Write(buf.Bytes())
 }
 
 type passwordUpdateForm struct {
 	CurrentPassword         string `form:"currentPassword"`
 	NewPassword             string `form:"newPassword"`
 	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	LogoutOtherSessions     bool   `form:"logoutOtherSessions"`
 	validator.Validator     `form:"-"`
 }
 
 func (app *application) userPasswordUpdateForm(w http.ResponseWriter, r *http.Request) {
 	templData := app.newTemplateData(r)
-	templData.Form = passwordUpdateForm{}
+	// Offer to log the user out of their other sessions by default, in case
+	// they're changing their password because it was compromised.
+	templData.Form = passwordUpdateForm{LogoutOtherSessions: true}
 
-	app.render(w, http.StatusOK, "password.html", templData)
+	app.render(w, r, http.StatusOK, "password.html", templData)
 }
 
 func (app *application) userPasswordUpdate(w http.ResponseWriter, r *http.Request) {
@@ -422,23 +2049,32 @@ func (app *application) userPasswordUpdate(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Get the user's details, so that we can check that the new password
+	// doesn't contain their name or email address.
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.NewPassword, 16), "newPassword", "This field must be at least 16 characters long")
+	err = app.passwordPolicy.CheckPassword(&form.Validator, "newPassword", form.NewPassword, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
 	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
 
 	if !form.Valid() {
 		templData := app.newTemplateData(r)
-		templData.Form = passwordUpdateForm{}
+		templData.Form = form
 
-		app.render(w, http.StatusUnprocessableEntity, "password.html", templData)
+		app.render(w, r, http.StatusUnprocessableEntity, "password.html", templData)
 		return
 	}
 
-	userID := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
-
-	err = app.users.PasswordUpdate(uuid.MustParse(userID), form.CurrentPassword, form.NewPassword)
+	err = app.users.PasswordUpdate(app.authenticatedUserID(r), form.CurrentPassword, form.NewPassword)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
 			form.AddFieldError("currentPassword", "Current password is incorrect")
@@ -446,14 +2082,447 @@ func (app *application) userPasswordUpdate(w http.ResponseWriter, r *http.Reques
 			templData := app.newTemplateData(r)
 			templData.Form = form
 
-			app.render(w, http.StatusUnprocessableEntity, "password.html", templData)
+			app.render(w, r, http.StatusUnprocessableEntity, "password.html", templData)
 		} else if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// If the user asked for it, log them out of every other session.
+	if form.LogoutOtherSessions {
+		err = app.destroyUserSessions(r.Context(), app.authenticatedUserID(r), app.sessionManager.Token(r.Context()))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "flash", "Your password has been updated, and your other sessions have been logged out!")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+	}
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Define how long password reset links stay valid for.
+const passwordResetTTL = time.Hour
+
+// Create a forgotPasswordForm struct to represent the form for requesting a
+// password reset link.
+type forgotPasswordForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// Define a userPasswordForgotForm handler func which displays the form for
+// requesting a password reset link.
+func (app *application) userPasswordForgotForm(w http.ResponseWriter, r *http.Request) {
+	templData := app.newTemplateData(r)
+	templData.Form = forgotPasswordForm{}
+
+	app.render(w, r, http.StatusOK, "forgot.html", templData)
+}
+
+// Define a userPasswordForgot handler func which emails a password reset link
+// to the given address. The same message is shown whether or not an account
+// exists for the address, so that the form can't be used to find out who
+// has an account.
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		templData := app.newTemplateData(r)
+		templData.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.html", templData)
+		return
+	}
+
+	token, err := app.passwordResets.New(form.Email, passwordResetTTL)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only send the email if there is an account for the address.
+	if err == nil {
+		data := map[string]any{
+			"URL":     fmt.Sprintf("%s/user/password/reset/%s", app.baseURL, token),
+			"Expires": "1 hour",
+		}
+
+		err = app.sendEmail(form.Email, "password_reset.tmpl", data)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If there is an account for that email address, we've sent it a link to reset your password.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Create a resetPasswordForm struct to represent the form for choosing a new
+// password.
+type resetPasswordForm struct {
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	Token                   string `form:"-"`
+	validator.Validator     `form:"-"`
+}
+
+// Define a userPasswordResetForm handler func which displays the form for
+// choosing a new password, if the reset token in the URL is valid.
+func (app *application) userPasswordResetForm(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	_, err := app.passwordResets.Check(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired. Please request a new one.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Form = resetPasswordForm{Token: token}
+
+	app.render(w, r, http.StatusOK, "reset.html", templData)
+}
+
+// Define a userPasswordReset handler func which sets a new password using a
+// reset token. The token is used up, and the user is logged out of all of
+// their other sessions, so that anybody who knew the old password loses
+// access.
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	var form resetPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Token = token
+
+	// Look up who the token belongs to, so that we can check that the new
+	// password doesn't contain their name or email address.
+	userID, err := app.passwordResets.Check(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired. Please request a new one.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.passwordPolicy.CheckPassword(&form.Validator, "newPassword", form.NewPassword, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	if !form.Valid() {
+		templData := app.newTemplateData(r)
+		templData.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.html", templData)
+		return
+	}
+
+	userID, err = app.passwordResets.Reset(token, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired. Please request a new one.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.destroyUserSessions(r.Context(), userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// The twoFactorEnrollment helper returns the TOTP secret which the
+// authenticated user is enrolling with. The secret is kept in the session
+// until the user has confirmed it with a valid code, so that reloading the
+// enrollment page doesn't change the QR code.
+func (app *application) twoFactorEnrollment(r *http.Request) (string, error) {
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorPendingSecret")
+	if secret != "" {
+		return secret, nil
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	app.sessionManager.Put(r.Context(), "twoFactorPendingSecret", secret)
+
+	return secret, nil
+}
+
+// The renderTwoFactorEnable helper renders the two-factor enrollment page for
+// the given user and secret, with the otpauth URI shown as a QR code.
+func (app *application) renderTwoFactorEnable(w http.ResponseWriter, r *http.Request, status int, user *models.User, secret string, form twoFactorForm) {
+	uri := totp.URI("Snippetbox", user.Email, secret)
+
+	qrCode, err := qrCodeSVG(uri)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.User = user
+	templData.TwoFactorSecret = secret
+	templData.TwoFactorURI = template.URL(uri)
+	templData.QRCode = qrCode
+	templData.Form = form
+
+	app.render(w, r, status, "twofactor.html", templData)
+}
+
+// Define an accountTwoFactorEnableForm handler func which displays the QR
+// code and secret for setting up an authenticator app.
+func (app *application) accountTwoFactorEnableForm(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := app.twoFactor.Get(user.ID)
+	if err == nil {
+		app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is already enabled.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	secret, err := app.twoFactorEnrollment(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderTwoFactorEnable(w, r, http.StatusOK, user, secret, twoFactorForm{})
+}
+
+// Define an accountTwoFactorEnable handler func which turns on two-factor
+// authentication once the user has entered a valid code from their
+// authenticator app. The user's recovery codes are shown once, on the page
+// rendered in response.
+func (app *application) accountTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorPendingSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/account/2fa/enable", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	step, valid := totp.Validate(secret, form.Code, app.clock(), 1)
+	if form.Valid() && !valid {
+		form.AddFieldError("code", "Authentication code is incorrect")
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactorEnable(w, r, http.StatusUnprocessableEntity, user, secret, form)
+		return
+	}
+
+	codes, err := models.GenerateRecoveryCodes(10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.twoFactor.Enable(user.ID, secret, step, codes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "twoFactorPendingSecret")
+
+	templData := app.newTemplateData(r)
+	templData.RecoveryCodes = codes
+
+	app.render(w, r, http.StatusOK, "recovery.html", templData)
+}
+
+// Define an accountTwoFactorDisable handler func which turns off two-factor
+// authentication. A valid authentication or recovery code is needed, so
+// that somebody with access to an unattended session can't turn it off.
+func (app *application) accountTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	tf, err := app.twoFactor.Get(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Limit the number of codes which can be tried, otherwise the code could
+	// be guessed by brute force.
+	locked, err := app.reauthAttempt(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if locked > 0 {
+		app.sessionManager.Put(r.Context(), "flash", reauthLockoutMessage(locked)+" Two-factor authentication is still enabled.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	_, ok, err := app.checkTwoFactorCode(tf, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !ok {
+		locked, err := app.reauthFailed(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
 		}
+
+		message := "Authentication code is incorrect."
+		if locked > 0 {
+			message = reauthLockoutMessage(locked)
+		}
+
+		app.sessionManager.Put(r.Context(), "flash", message+" Two-factor authentication is still enabled.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	err = app.reauthSucceeded(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.twoFactor.Disable(userID)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been disabled.")
 
 	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
+
+// Define an adminLockouts handler func which lists the accounts and IP
+// addresses with recent failed logins, so that an administrator can see who
+// is locked out.
+func (app *application) adminLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := app.lockouts.List(app.clock())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templData := app.newTemplateData(r)
+	templData.Lockouts = lockouts
+
+	app.render(w, r, http.StatusOK, "lockouts.html", templData)
+}
+
+// Create an unlockForm struct to represent the form for unlocking an account
+// or IP address.
+type unlockForm struct {
+	Key                 string `form:"key"`
+	validator.Validator `form:"-"`
+}
+
+// Define an adminLockoutUnlock handler func which forgets the failed logins
+// for an account or IP address, so that it can be used to log in again
+// straight away.
+func (app *application) adminLockoutUnlock(w http.ResponseWriter, r *http.Request) {
+	var form unlockForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !validator.NotBlank(form.Key) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.lockouts.Delete(form.Key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s has been unlocked!", form.Key))
+
+	http.Redirect(w, r, "/admin/lockouts", http.StatusSeeOther)
+}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"archive/zip"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/mailer"
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/Avixph/learn-go-snippetbox/internal/models/mocks"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc/oidctest"
+	"github.com/google/uuid"
 )
 
 func TestPing(t *testing.T) {
@@ -53,6 +65,18 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
+		{
+			name:     "Shows language",
+			urlPath:  "/snippet/view/6ba7b812-9dad-11d1-80b4-00c04fd430c8",
+			wantCode: http.StatusOK,
+			wantBody: `<code class="language-go">`,
+		},
+		{
+			name:     "Shows author",
+			urlPath:  "/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			wantCode: http.StatusOK,
+			wantBody: "By: Nom Falso",
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  "/snippet/view/3fa80338-89b5-407e-b294-c3ac68238070",
@@ -177,6 +201,24 @@ func TestUserSignup(t *testing.T) {
 			wantCode:     http.StatusUnprocessableEntity,
 			wantFormTag:  formTag,
 		},
+		{
+			name:         "Breached password",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: breachedPassword,
+			csrfToken:    csrfToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Password contains name",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: "my name is nom falso",
+			csrfToken:    csrfToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
 		{
 			name:         "Duplicate email",
 			userName:     validName,
@@ -227,7 +269,7 @@ func TestSnippetCreate(t *testing.T) {
 
 		const (
 			validEmail    = "falso@example.com"
-			validPassword = "pa$$w0rd8923"
+			validPassword = "1376p@$$w0rd8923"
 			validFormTag  = `<form action="/snippet/create" method="POST">`
 		)
 
@@ -245,4 +287,2243 @@ func TestSnippetCreate(t *testing.T) {
 		assert.Equal(t, code, http.StatusOK)
 		assert.StringContains(t, body, validFormTag)
 	})
+	t.Run("Unverified email", func(t *testing.T) {
+		// Use a new test server, so that we aren't still logged in as the
+		// verified user.
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.login(t, "nuevo@example.com", "1376p@$$w0rd8923")
+
+		// Check that users who haven't verified their email address are sent
+		// to their account page instead of the create snippet form.
+		code, headers, _ := ts.get(t, "/snippet/create")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/account/view")
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Please verify your email address first.")
+		assert.StringContains(t, body, `<form action="/account/verify/resend" method="POST" class="inline">`)
+	})
+}
+
+func TestAccountView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/account/view")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	t.Run("Authenticated", func(t *testing.T) {
+		ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+		// Check that the account page lists the snippets created by the user.
+		code, _, body := ts.get(t, "/account/view")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "My Snippets")
+		assert.StringContains(t, body, `<a href="/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8">An old silent pond</a>`)
+
+		// Check that the account page lists the user's API tokens.
+		assert.StringContains(t, body, "<td>CI deploys</td>")
+		assert.StringContains(t, body, `<form action="/account/token/revoke/6ba7b815-9dad-11d1-80b4-00c04fd430c8" method="POST">`)
+	})
+}
+
+func TestTokenCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/account/view")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name      string
+		tokenName string
+		scope     string
+		wantCode  int
+		wantBody  string
+	}{
+		{
+			name:      "Valid submission",
+			tokenName: "Deploy script",
+			scope:     "write",
+			wantCode:  http.StatusOK,
+			wantBody:  "<code>sbx_newtokennewtokennewtokennewtok</code>",
+		},
+		{
+			name:      "Blank name",
+			tokenName: "",
+			scope:     "read",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field cannot be blank!",
+		},
+		{
+			name:      "Invalid scope",
+			tokenName: "Deploy script",
+			scope:     "admin",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field must equal read or write!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.tokenName)
+			form.Add("scope", tt.scope)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/account/token/create", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestTokenRevoke(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/account/view")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Own token",
+			urlPath:      "/account/token/revoke/6ba7b815-9dad-11d1-80b4-00c04fd430c8",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:     "Non-existent token",
+			urlPath:  "/account/token/revoke/3fa80338-89b5-407e-b294-c3ac68238070",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid ID",
+			urlPath:  "/account/token/revoke/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", validCSRFToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestSnippetEdit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const (
+		ownedPath = "/snippet/edit/6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+		otherPath = "/snippet/edit/6ba7b812-9dad-11d1-80b4-00c04fd430c8"
+		formTag   = `<form action="/snippet/edit/6ba7b810-9dad-11d1-80b4-00c04fd430c8" method="POST">`
+	)
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, headers, _ := ts.get(t, ownedPath)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	t.Run("Owner sees form", func(t *testing.T) {
+		code, _, body := ts.get(t, ownedPath)
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, formTag)
+		assert.StringContains(t, body, `value="An old silent pond"`)
+
+		// The current expiry should be kept unless a new one is picked.
+		assert.StringContains(t, body, `value="0"
+      title="Keep Current"
+      checked`)
+	})
+
+	t.Run("Non-owner is forbidden", func(t *testing.T) {
+		code, _, _ := ts.get(t, otherPath)
+
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	_, _, body := ts.get(t, ownedPath)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		title       string
+		content     string
+		language    string
+		tags        string
+		expires     string
+		wantCode    int
+		wantFormTag string
+	}{
+		{
+			name:     "Valid submission",
+			urlPath:  ownedPath,
+			title:    "An old silent pond",
+			content:  "A frog jumps into the pond,\nsplash! Silence again.",
+			language: "plaintext",
+			tags:     "Haiku, poetry",
+			expires:  "7",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Keep expiry",
+			urlPath:  ownedPath,
+			title:    "An old silent pond",
+			content:  "A frog jumps into the pond,\nsplash! Silence again.",
+			language: "plaintext",
+			expires:  "0",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:        "Empty title",
+			urlPath:     ownedPath,
+			title:       "",
+			content:     "A frog jumps into the pond",
+			expires:     "7",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:        "Content too long",
+			urlPath:     ownedPath,
+			title:       "An old silent pond",
+			content:     strings.Repeat("a", snippetContentMaxChars+1),
+			language:    "plaintext",
+			expires:     "7",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:        "Invalid expiry",
+			urlPath:     ownedPath,
+			title:       "An old silent pond",
+			content:     "A frog jumps into the pond",
+			expires:     "30",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:        "Invalid language",
+			urlPath:     ownedPath,
+			title:       "An old silent pond",
+			content:     "A frog jumps into the pond",
+			language:    "cobol",
+			expires:     "7",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:        "Too many tags",
+			urlPath:     ownedPath,
+			title:       "An old silent pond",
+			content:     "A frog jumps into the pond",
+			tags:        "one two three four five six",
+			expires:     "7",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:        "Invalid tag",
+			urlPath:     ownedPath,
+			title:       "An old silent pond",
+			content:     "A frog jumps into the pond",
+			tags:        "haiku, -poetry",
+			expires:     "7",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:     "Non-owner",
+			urlPath:  otherPath,
+			title:    "Over the wintry forest",
+			content:  "Winds howl in rage",
+			expires:  "7",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("language", tt.language)
+			form.Add("tags", tt.tags)
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantFormTag != "" {
+				assert.StringContains(t, body, tt.wantFormTag)
+			}
+		})
+	}
+}
+
+func TestSnippetDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	assert.StringContains(t, body, `<form action="/snippet/delete/6ba7b810-9dad-11d1-80b4-00c04fd430c8" method="POST">`)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Owner",
+			urlPath:      "/snippet/delete/6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:     "Non-owner",
+			urlPath:  "/snippet/delete/6ba7b812-9dad-11d1-80b4-00c04fd430c8",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/delete/3fa80338-89b5-407e-b294-c3ac68238070",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestSnippetHistory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid ID",
+			urlPath:  "/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8/history",
+			wantCode: http.StatusOK,
+			wantBody: `<form action="/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8/diff" method="GET">`,
+		},
+		{
+			name:     "No revisions",
+			urlPath:  "/snippet/view/6ba7b812-9dad-11d1-80b4-00c04fd430c8/history",
+			wantCode: http.StatusOK,
+			wantBody: "There's no history for this snippet... yet!",
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/view/3fa80338-89b5-407e-b294-c3ac68238070/history",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetDiff(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const basePath = "/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8/diff"
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid revisions",
+			urlPath:  basePath + "?from=1&to=2",
+			wantCode: http.StatusOK,
+			wantBody: `<span class="diff-insert">&#43;A frog jumps into the pond,</span>`,
+		},
+		{
+			name:     "Same revision",
+			urlPath:  basePath + "?from=2&to=2",
+			wantCode: http.StatusOK,
+			wantBody: "No differences in the content.",
+		},
+		{
+			name:     "Missing revision",
+			urlPath:  basePath + "?from=1",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-numeric revision",
+			urlPath:  basePath + "?from=one&to=2",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent revision",
+			urlPath:  basePath + "?from=1&to=3",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Empty query",
+			urlPath:  "/search",
+			wantCode: http.StatusOK,
+			wantBody: `<form action="/search" method="GET" novalidate>`,
+		},
+		{
+			name:     "Matching query",
+			urlPath:  "/search?q=silent",
+			wantCode: http.StatusOK,
+			wantBody: "An old <mark>silent</mark> pond...",
+		},
+		{
+			name:     "No matches",
+			urlPath:  "/search?q=frog",
+			wantCode: http.StatusOK,
+			wantBody: `No snippets matched "frog".`,
+		},
+		{
+			name:     "Long query",
+			urlPath:  "/search?q=" + strings.Repeat("a", 201),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 200 characters long!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHome(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	cursor := models.Cursor{
+		CreatedOn: time.Date(2023, 1, 23, 13, 25, 37, 0, time.UTC),
+		ID:        uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"),
+	}.String()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "First page",
+			urlPath:  "/",
+			wantCode: http.StatusOK,
+			wantBody: []string{"An old silent pond", `class="next"`},
+		},
+		{
+			name:     "After cursor",
+			urlPath:  "/?after=" + cursor,
+			wantCode: http.StatusOK,
+			wantBody: []string{`class="prev"`, `class="next"`},
+		},
+		{
+			name:     "Keeps page size",
+			urlPath:  "/?limit=5",
+			wantCode: http.StatusOK,
+			wantBody: []string{"limit=5"},
+		},
+		{
+			name:     "Page size above maximum",
+			urlPath:  "/?limit=1000",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Tag filter",
+			urlPath:  "/?tags=haiku,poetry",
+			wantCode: http.StatusOK,
+			wantBody: []string{"An old silent pond", `<a class="tag" href="/tag/haiku">haiku</a>`},
+		},
+		{
+			name:     "Tag filter without matches",
+			urlPath:  "/?tags=haiku,sql",
+			wantCode: http.StatusOK,
+			wantBody: []string{"There's nothing to see here... yet!"},
+		},
+		{
+			name:     "Invalid cursor",
+			urlPath:  "/?after=not-a-cursor",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Both cursors",
+			urlPath:  "/?after=" + cursor + "&before=" + cursor,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Invalid page size",
+			urlPath:  "/?limit=0",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+}
+
+func TestTagView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid tag",
+			urlPath:  "/tag/haiku",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Uppercase tag",
+			urlPath:  "/tag/HAIKU",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Unused tag",
+			urlPath:  "/tag/sql",
+			wantCode: http.StatusOK,
+			wantBody: "There's nothing to see here... yet!",
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tag/-haiku",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetRaw(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid ID", func(t *testing.T) {
+		code, headers, body := ts.get(t, "/snippet/raw/6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, headers.Get("Content-Type"), "text/plain; charset=utf-8")
+		assert.Equal(t, headers.Get("Content-Length"), "21")
+		assert.Equal(t, body, "An old silent pond...")
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/raw/3fa80338-89b5-407e-b294-c3ac68238070")
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestSnippetDownload(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid ID", func(t *testing.T) {
+		code, headers, body := ts.get(t, "/snippet/download/6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, headers.Get("Content-Type"), "text/plain; charset=utf-8")
+		assert.Equal(t, headers.Get("Content-Disposition"), "attachment; filename=over-the-wintry-forest.go")
+		assert.Equal(t, body, "Over the wintry forest...")
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/snippet/download/3fa80338-89b5-407e-b294-c3ac68238070")
+
+		assert.Equal(t, code, http.StatusNotFound)
+		assert.Equal(t, headers.Get("Content-Disposition"), "")
+	})
+}
+
+func TestUserPasswordForgot(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		email        string
+		wantCode     int
+		wantLocation string
+		wantEmail    bool
+	}{
+		{
+			name:         "Existing account",
+			email:        "falso@example.com",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+			wantEmail:    true,
+		},
+		{
+			name:         "Unknown account",
+			email:        "nadie@example.com",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+		{
+			name:     "Invalid email",
+			email:    "falso@example.",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Use a fresh mailer for each sub-test so that we can check whether
+			// an email was sent.
+			mail := &mailer.Memory{}
+			app.mailer = mail
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, headers, _ := ts.postForm(t, "/user/password/forgot", form)
+
+			// Wait for the background goroutine sending the email to finish.
+			app.wg.Wait()
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+
+			msg, sent := mail.Last()
+			assert.Equal(t, sent, tt.wantEmail)
+			if tt.wantEmail {
+				assert.Equal(t, msg.To, tt.email)
+				assert.StringContains(t, msg.Body, "https://snippetbox.test/user/password/reset/"+mocks.ResetToken)
+			}
+		})
+	}
+}
+
+func TestUserPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	validPath := "/user/password/reset/" + mocks.ResetToken
+
+	t.Run("Invalid token", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/user/password/reset/expired")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/password/forgot")
+	})
+
+	// Log in as the user in a separate cookie jar, so that we can check that
+	// the session is destroyed by the reset.
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+	otherDevice := ts.Client().Jar
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+
+	code, _, body := ts.get(t, validPath)
+	assert.Equal(t, code, http.StatusOK)
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		password     string
+		confirmation string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Passwords don't match",
+			password:     "a new secure password",
+			confirmation: "a different password",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Short password",
+			password:     "short",
+			confirmation: "short",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Breached password",
+			password:     breachedPassword,
+			confirmation: breachedPassword,
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Valid submission",
+			password:     "a new secure password",
+			confirmation: "a new secure password",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("newPassword", tt.password)
+			form.Add("newPasswordConfirmation", tt.confirmation)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, headers, _ := ts.postForm(t, validPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	t.Run("Other sessions logged out", func(t *testing.T) {
+		ts.Client().Jar = otherDevice
+
+		code, headers, _ := ts.get(t, "/account/view")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+}
+
+func TestUserSignupSendsVerification(t *testing.T) {
+	app := newTestApplication(t)
+	mail := &mailer.Memory{}
+	app.mailer = mail
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "1376p@$$w0rd8923")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	app.wg.Wait()
+
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	msg, sent := mail.Last()
+	assert.Equal(t, sent, true)
+	assert.Equal(t, msg.To, "bob@example.com")
+	assert.StringContains(t, msg.Body, "Hi Bob,")
+	assert.StringContains(t, msg.Body, "https://snippetbox.test/user/verify/"+mocks.VerificationToken)
+}
+
+func TestAccountVerifyResend(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		email     string
+		wantFlash string
+		wantEmail bool
+	}{
+		{
+			name:      "Unverified",
+			email:     "nuevo@example.com",
+			wantFlash: "We&#39;ve sent a new verification link to nuevo@example.com.",
+			wantEmail: true,
+		},
+		{
+			name:      "Already verified",
+			email:     "falso@example.com",
+			wantFlash: "Your email address is already verified.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailer.Memory{}
+			app.mailer = mail
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, tt.email, "1376p@$$w0rd8923")
+
+			_, _, body := ts.get(t, "/account/view")
+
+			form := url.Values{}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ := ts.postForm(t, "/account/verify/resend", form)
+			app.wg.Wait()
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/account/view")
+
+			_, sent := mail.Last()
+			assert.Equal(t, sent, tt.wantEmail)
+
+			_, _, body = ts.get(t, "/account/view")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+func TestUserVerifyEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantFlash string
+	}{
+		{
+			name:      "Valid token",
+			urlPath:   "/user/verify/" + mocks.VerificationToken,
+			wantFlash: "Your email address has been verified!",
+		},
+		{
+			name:      "Invalid token",
+			urlPath:   "/user/verify/expired",
+			wantFlash: "That verification link is invalid or has expired.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+func TestUserLoginTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("No pending login", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, headers, _ := ts.get(t, "/user/login/2fa")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid code",
+			code:         totpCode(t, mocks.TwoFactorSecret, testTime),
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:         "Recovery code",
+			code:         mocks.RecoveryCode,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:     "Expired code",
+			code:     totpCode(t, mocks.TwoFactorSecret, testTime.Add(-5*time.Minute)),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Authentication code is incorrect",
+		},
+		{
+			name:     "Blank code",
+			code:     "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// The password step should redirect to the second step, without
+			// logging the user in.
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("email", "dos@example.com")
+			form.Add("password", "1376p@$$w0rd8923")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login/2fa")
+
+			// Check that the user isn't logged in yet. This also means they
+			// should be sent back to the account page once they are.
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			_, _, body = ts.get(t, "/user/login/2fa")
+
+			form = url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, body = ts.postForm(t, "/user/login/2fa", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAccountTwoFactorEnable(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	code, _, body := ts.get(t, "/account/2fa/enable")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<svg")
+	assert.StringContains(t, body, "otpauth://totp/Snippetbox:falso@example.com?")
+
+	validCSRFToken := extractCSRFToken(t, body)
+
+	// Pull the pending secret out of the page, so that we can generate a
+	// valid code for it.
+	_, after, _ := strings.Cut(body, `<code class="secret">`)
+	secret, _, _ := strings.Cut(after, "</code>")
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Wrong code",
+			code:     "000000",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Authentication code is incorrect",
+		},
+		{
+			name:     "Valid code",
+			code:     totpCode(t, secret, testTime),
+			wantCode: http.StatusOK,
+			wantBody: "Save these recovery codes somewhere safe.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/account/2fa/enable", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAccountTwoFactorDisable(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		code      string
+		wantFlash string
+	}{
+		{
+			name:      "Valid code",
+			code:      totpCode(t, mocks.TwoFactorSecret, testTime),
+			wantFlash: "Two-factor authentication has been disabled.",
+		},
+		{
+			name:      "Wrong code",
+			code:      "000000",
+			wantFlash: "Authentication code is incorrect. Two-factor authentication is still enabled.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// Complete both login steps.
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("email", "dos@example.com")
+			form.Add("password", "1376p@$$w0rd8923")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			ts.postForm(t, "/user/login", form)
+
+			form = url.Values{}
+			form.Add("code", mocks.RecoveryCode)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			ts.postForm(t, "/user/login/2fa", form)
+
+			_, _, body = ts.get(t, "/account/view")
+			assert.StringContains(t, body, "Enabled (10 recovery codes left)")
+
+			form = url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ := ts.postForm(t, "/account/2fa/disable", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/account/view")
+
+			_, _, body = ts.get(t, "/account/view")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+func TestAccountTwoFactorDisableLockout(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Complete both login steps.
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", "dos@example.com")
+	form.Add("password", "1376p@$$w0rd8923")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/login", form)
+
+	form = url.Values{}
+	form.Add("code", mocks.RecoveryCode)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/login/2fa", form)
+
+	disable := func(code string) string {
+		_, _, body := ts.get(t, "/account/view")
+
+		form := url.Values{}
+		form.Add("code", code)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		ts.postForm(t, "/account/2fa/disable", form)
+
+		_, _, body = ts.get(t, "/account/view")
+		return body
+	}
+
+	for i := 1; i < accountLoginPolicy.Threshold; i++ {
+		body = disable("000000")
+		assert.StringContains(t, body, "Authentication code is incorrect.")
+	}
+
+	body = disable("000000")
+	assert.StringContains(t, body, "Too many incorrect attempts. Please try again in a minute.")
+
+	// A valid code shouldn't be accepted while the user is locked out.
+	body = disable(totpCode(t, mocks.TwoFactorSecret, testTime))
+	assert.StringContains(t, body, "Too many incorrect attempts. Please try again in a minute.")
+	assert.StringContains(t, body, "Enabled (10 recovery codes left)")
+}
+
+// The newDevice helper gives the test server client an empty cookie jar, as
+// if the following requests came from a different device. It returns the
+// previous jar so that the test can switch back to it.
+func newDevice(t *testing.T, ts *testServer) http.CookieJar {
+	previous := ts.Client().Jar
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+
+	return previous
+}
+
+// The currentSessionID helper returns the ID of the test server client's
+// current session, as shown on the active sessions page.
+func currentSessionID(t *testing.T, ts *testServer) string {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == "session" {
+			return sessionID(c.Value)
+		}
+	}
+
+	t.Fatal("no session cookie found")
+	return ""
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+	otherDevice := newDevice(t, ts)
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	code, _, body := ts.get(t, "/account/sessions")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Count(body, `action="/account/sessions/revoke/`), 2)
+	assert.StringContains(t, body, "Go-http-client/1.1 <strong>(this device)</strong>")
+	assert.StringContains(t, body, "127.0.0.1")
+	assert.StringContains(t, body, "17 Mar 2024 at 10:15")
+	assert.StringContains(t, body, "Log out everywhere else")
+
+	validCSRFToken := extractCSRFToken(t, body)
+
+	t.Run("Unknown session", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", validCSRFToken)
+
+		code, _, _ := ts.postForm(t, "/account/sessions/revoke/0123456789abcdef", form)
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Other device", func(t *testing.T) {
+		device := ts.Client().Jar
+		ts.Client().Jar = otherDevice
+		id := currentSessionID(t, ts)
+		ts.Client().Jar = device
+
+		form := url.Values{}
+		form.Add("csrf_token", validCSRFToken)
+
+		code, headers, _ := ts.postForm(t, "/account/sessions/revoke/"+id, form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/account/sessions")
+
+		_, _, body := ts.get(t, "/account/sessions")
+		assert.Equal(t, strings.Count(body, `action="/account/sessions/revoke/`), 1)
+
+		ts.Client().Jar = otherDevice
+		code, _, _ = ts.get(t, "/account/view")
+		ts.Client().Jar = device
+
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("This device", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", validCSRFToken)
+
+		code, headers, _ := ts.postForm(t, "/account/sessions/revoke/"+currentSessionID(t, ts), form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/")
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestAccountSessionRevokeOthers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+	otherDevice := newDevice(t, ts)
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/account/sessions")
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, headers, _ := ts.postForm(t, "/account/sessions/revoke-others", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/sessions")
+
+	// This device should still be logged in, but the other one shouldn't.
+	code, _, body = ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "You&#39;ve been logged out everywhere else!")
+	assert.Equal(t, strings.Count(body, `action="/account/sessions/revoke/`), 1)
+
+	ts.Client().Jar = otherDevice
+
+	code, headers, _ = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+}
+
+func TestUserPasswordUpdate(t *testing.T) {
+	tests := []struct {
+		name                string
+		logoutOtherSessions bool
+		wantOtherCode       int
+	}{
+		{"Log out other sessions", true, http.StatusSeeOther},
+		{"Keep other sessions", false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+			otherDevice := newDevice(t, ts)
+			ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+			_, _, body := ts.get(t, "/account/password/update")
+			assert.StringContains(t, body, `name="logoutOtherSessions" value="true" checked`)
+
+			form := url.Values{}
+			form.Add("currentPassword", "1376p@$$w0rd8923")
+			form.Add("newPassword", "a new secure password")
+			form.Add("newPasswordConfirmation", "a new secure password")
+			if tt.logoutOtherSessions {
+				form.Add("logoutOtherSessions", "true")
+			}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ := ts.postForm(t, "/account/password/update", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/account/view")
+
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusOK)
+
+			ts.Client().Jar = otherDevice
+
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, tt.wantOtherCode)
+		})
+	}
+}
+
+// The tryLogin helper submits the login form with the given credentials, and
+// returns the response status code and body.
+func tryLogin(t *testing.T, ts *testServer, email, password string) (int, http.Header, string) {
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	return ts.postForm(t, "/user/login", form)
+}
+
+func TestUserLoginLockout(t *testing.T) {
+	t.Run("Account", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		for i := 1; i < accountLoginPolicy.Threshold; i++ {
+			code, _, body := tryLogin(t, ts, "falso@example.com", "wrong password")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, body, "Email or password is incorrect")
+		}
+
+		code, headers, body := tryLogin(t, ts, "falso@example.com", "wrong password")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, headers.Get("Retry-After"), "30")
+		assert.StringContains(t, body, "Too many failed login attempts. Please try again in a minute.")
+
+		// The correct password shouldn't be accepted while the account is
+		// locked, but other accounts should still work.
+		code, _, _ = tryLogin(t, ts, "falso@example.com", "1376p@$$w0rd8923")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+
+		code, _, _ = tryLogin(t, ts, "nuevo@example.com", "1376p@$$w0rd8923")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// Once the lock has passed, another failure doubles it.
+		app.clock = func() time.Time { return testTime.Add(time.Minute) }
+
+		code, headers, _ = tryLogin(t, ts, "FALSO@example.com", "wrong password")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, headers.Get("Retry-After"), "60")
+
+		app.clock = func() time.Time { return testTime.Add(2 * time.Minute) }
+
+		code, _, _ = tryLogin(t, ts, "falso@example.com", "1376p@$$w0rd8923")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("IP address", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Spread the failures across many accounts, so that none of them is
+		// locked on its own.
+		for i := 1; i < ipLoginPolicy.Threshold; i++ {
+			code, _, _ := tryLogin(t, ts, fmt.Sprintf("user%d@example.com", i), "wrong password")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+
+		code, _, _ := tryLogin(t, ts, "another@example.com", "wrong password")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+
+		code, _, _ = tryLogin(t, ts, "falso@example.com", "1376p@$$w0rd8923")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+	})
+
+	t.Run("Two-factor codes", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		for i := 1; i <= accountLoginPolicy.Threshold; i++ {
+			code, _, _ := tryLogin(t, ts, "dos@example.com", "1376p@$$w0rd8923")
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			_, _, body := ts.get(t, "/user/login/2fa")
+
+			form := url.Values{}
+			form.Add("code", "000000")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+			if i < accountLoginPolicy.Threshold {
+				assert.Equal(t, code, http.StatusUnprocessableEntity)
+			} else {
+				assert.Equal(t, code, http.StatusTooManyRequests)
+			}
+		}
+
+		code, _, _ := tryLogin(t, ts, "dos@example.com", "1376p@$$w0rd8923")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+	})
+}
+
+func TestAdminLockouts(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for i := 0; i < accountLoginPolicy.Threshold; i++ {
+		tryLogin(t, ts, "dos@example.com", "wrong password")
+	}
+
+	t.Run("Not an admin", func(t *testing.T) {
+		previous := newDevice(t, ts)
+		defer func() { ts.Client().Jar = previous }()
+
+		ts.login(t, "nuevo@example.com", "1376p@$$w0rd8923")
+
+		code, _, _ := ts.get(t, "/admin/lockouts")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	code, _, body := ts.get(t, "/admin/lockouts")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<td>account:dos@example.com</td>")
+	assert.StringContains(t, body, "<td>ip:127.0.0.1</td>")
+
+	form := url.Values{}
+	form.Add("key", "account:dos@example.com")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, headers, _ := ts.postForm(t, "/admin/lockouts/unlock", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/admin/lockouts")
+
+	_, _, body = ts.get(t, "/admin/lockouts")
+	assert.StringContains(t, body, "account:dos@example.com has been unlocked!")
+	assert.Equal(t, strings.Contains(body, "<td>account:dos@example.com</td>"), false)
+
+	newDevice(t, ts)
+	code, headers, _ = tryLogin(t, ts, "dos@example.com", "1376p@$$w0rd8923")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login/2fa")
+}
+
+func TestUserPasswordUpdateValidation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/account/password/update")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		password string
+		wantBody string
+	}{
+		{
+			name:     "Short password",
+			password: "short",
+			wantBody: "This field must be at least 16 characters long",
+		},
+		{
+			name:     "Contains email",
+			password: "falso@example.com!!",
+			wantBody: "This field must not contain your name or email address",
+		},
+		{
+			name:     "Breached password",
+			password: breachedPassword,
+			wantBody: "This password has appeared in a data breach, please choose a different one",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("currentPassword", "1376p@$$w0rd8923")
+			form.Add("newPassword", tt.password)
+			form.Add("newPasswordConfirmation", tt.password)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/account/password/update", form)
+
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAccountDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		confirmation string
+		wantCode     int
+		wantBody     string
+	}{
+		{
+			name:         "Valid submission",
+			password:     "1376p@$$w0rd8923",
+			confirmation: "DELETE",
+			wantCode:     http.StatusSeeOther,
+		},
+		{
+			name:         "Wrong password",
+			password:     "wrong password",
+			confirmation: "DELETE",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Password is incorrect",
+		},
+		{
+			name:         "Blank password",
+			password:     "",
+			confirmation: "DELETE",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This field cannot be blank",
+		},
+		{
+			name:         "Missing confirmation",
+			password:     "1376p@$$w0rd8923",
+			confirmation: "delete",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Please type DELETE to confirm",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+			_, _, body := ts.get(t, "/account/delete")
+			assert.StringContains(t, body, "your snippets will be deleted.")
+
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("confirmation", tt.confirmation)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, body := ts.postForm(t, "/account/delete", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+
+			// Once the account is deleted the user should be logged out.
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, headers.Get("Location"), "/")
+
+				code, headers, _ = ts.get(t, "/account/view")
+				assert.Equal(t, code, http.StatusSeeOther)
+				assert.Equal(t, headers.Get("Location"), "/user/login")
+			}
+		})
+	}
+}
+
+func TestAccountDeleteLockout(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	deleteAccount := func(password string) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/account/delete")
+
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("confirmation", "DELETE")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		return ts.postForm(t, "/account/delete", form)
+	}
+
+	for i := 1; i < accountLoginPolicy.Threshold; i++ {
+		code, _, body := deleteAccount("wrong password")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Password is incorrect")
+	}
+
+	code, headers, body := deleteAccount("wrong password")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "30")
+	assert.StringContains(t, body, "Too many incorrect attempts. Please try again in a minute.")
+
+	// The correct password shouldn't be accepted while the user is locked
+	// out.
+	code, _, _ = deleteAccount("1376p@$$w0rd8923")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	app.clock = func() time.Time { return testTime.Add(time.Minute) }
+
+	code, _, _ = deleteAccount("1376p@$$w0rd8923")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestAccountDeleteAnonymize(t *testing.T) {
+	app := newTestApplication(t)
+	app.snippetPolicy = models.AnonymizeSnippets
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/account/delete")
+	assert.StringContains(t, body, "your snippets will stay published without your name on them.")
+}
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Check that unauthenticated users are redirected to the login page.
+	code, headers, _ := ts.get(t, "/account/export")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	code, headers, body := ts.get(t, "/account/export")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "application/zip")
+	assert.Equal(t, headers.Get("Content-Disposition"), `attachment; filename=snippetbox-export-2024-03-17.zip`)
+
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	assert.NilError(t, err)
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NilError(t, err)
+
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		assert.NilError(t, err)
+
+		files[f.Name] = string(b)
+	}
+
+	assert.Equal(t, len(files), 2)
+	assert.StringContains(t, files["profile.json"], `"email": "falso@example.com"`)
+	assert.StringContains(t, files["profile.json"], `"exported_on": "2024-03-17T10:15:00Z"`)
+	assert.StringContains(t, files["snippets.json"], `"title": "An old silent pond"`)
+	assert.StringContains(t, files["snippets.json"], `"tags": [`)
+}
+
+func TestAccountUpdate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body := ts.get(t, "/account/update")
+	assert.StringContains(t, body, `value="Nom Falso"`)
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		userName string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid submission",
+			userName: "Nombre Nuevo",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Blank name",
+			userName: "   ",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Long name",
+			userName: strings.Repeat("a", 256),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 255 characters long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/account/update", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	// Check that only the valid change was recorded in the audit trail.
+	_, _, body = ts.get(t, "/account/activity")
+	assert.StringContains(t, body, "Changed name from &#34;Nom Falso&#34; to &#34;Nombre Nuevo&#34;")
+	assert.Equal(t, strings.Count(body, "Changed name"), 1)
+}
+
+func TestAccountEmailUpdate(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		email     string
+		password  string
+		wantCode  int
+		wantBody  string
+		wantEmail bool
+	}{
+		{
+			name:      "Valid submission",
+			email:     mocks.EmailChangeNewEmail,
+			password:  "1376p@$$w0rd8923",
+			wantCode:  http.StatusSeeOther,
+			wantEmail: true,
+		},
+		{
+			name:     "Duplicate email",
+			email:    "dos@example.com",
+			password: "1376p@$$w0rd8923",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Email address is already in use",
+		},
+		{
+			name:     "Current email",
+			email:    "falso@example.com",
+			password: "1376p@$$w0rd8923",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This is already your email address",
+		},
+		{
+			name:     "Invalid email",
+			email:    "bob@example.",
+			password: "1376p@$$w0rd8923",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a valid email address",
+		},
+		{
+			name:     "Wrong password",
+			email:    mocks.EmailChangeNewEmail,
+			password: "wrong password",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Password is incorrect",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailer.Memory{}
+			app.mailer = mail
+			app.auditLog = &mocks.AuditModel{}
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+			_, _, body := ts.get(t, "/account/email/update")
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, _, body := ts.postForm(t, "/account/email/update", form)
+			app.wg.Wait()
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+
+			msg, sent := mail.Last()
+			assert.Equal(t, sent, tt.wantEmail)
+
+			// The link should be sent to the new address, and the request
+			// recorded in the audit trail.
+			if tt.wantEmail {
+				assert.Equal(t, msg.To, tt.email)
+				assert.StringContains(t, msg.Body, "https://snippetbox.test/user/email/confirm/"+mocks.EmailChangeToken)
+
+				_, _, body = ts.get(t, "/account/activity")
+				assert.StringContains(t, body, "Requested a change of email address to "+tt.email)
+			}
+		})
+	}
+}
+
+func TestAccountEmailUpdateLockout(t *testing.T) {
+	app := newTestApplication(t)
+	mail := &mailer.Memory{}
+	app.mailer = mail
+	app.auditLog = &mocks.AuditModel{}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	updateEmail := func(password string) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/account/email/update")
+
+		form := url.Values{}
+		form.Add("email", mocks.EmailChangeNewEmail)
+		form.Add("password", password)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, headers, body := ts.postForm(t, "/account/email/update", form)
+		app.wg.Wait()
+
+		return code, headers, body
+	}
+
+	for i := 1; i < accountLoginPolicy.Threshold; i++ {
+		code, _, body := updateEmail("wrong password")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Password is incorrect")
+	}
+
+	code, headers, body := updateEmail("wrong password")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "30")
+	assert.StringContains(t, body, "Too many incorrect attempts. Please try again in a minute.")
+
+	// The correct password shouldn't be accepted while the user is locked
+	// out, and no link should be sent.
+	code, _, _ = updateEmail("1376p@$$w0rd8923")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	_, sent := mail.Last()
+	assert.Equal(t, sent, false)
+}
+
+func TestUserEmailConfirm(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantFlash string
+		wantEmail bool
+	}{
+		{
+			name:      "Valid token",
+			urlPath:   "/user/email/confirm/" + mocks.EmailChangeToken,
+			wantFlash: "Your email address has been changed!",
+			wantEmail: true,
+		},
+		{
+			name:      "Address taken",
+			urlPath:   "/user/email/confirm/" + mocks.EmailChangeTakenToken,
+			wantFlash: "That email address is already in use.",
+		},
+		{
+			name:      "Invalid token",
+			urlPath:   "/user/email/confirm/expired",
+			wantFlash: "That confirmation link is invalid or has expired.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailer.Memory{}
+			app.mailer = mail
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, headers, _ := ts.get(t, tt.urlPath)
+			app.wg.Wait()
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+
+			// A notice should be sent to the old address.
+			msg, sent := mail.Last()
+			assert.Equal(t, sent, tt.wantEmail)
+			if tt.wantEmail {
+				assert.Equal(t, msg.To, "falso@example.com")
+				assert.StringContains(t, msg.Body, "from\nfalso@example.com to "+mocks.EmailChangeNewEmail)
+			}
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+// The ssoLogin helper logs in through the fake identity provider, and returns
+// the response to the request which the provider redirects back with. The
+// callback URL can be changed with the tamper func before it's requested.
+func ssoLogin(t *testing.T, ts *testServer, tamper func(callback *url.URL)) (int, http.Header) {
+	code, headers, _ := ts.get(t, "/user/login/oidc/test")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	return followProvider(t, ts, headers.Get("Location"), tamper)
+}
+
+// The followProvider helper visits the fake identity provider's login page at
+// authURL, then requests the callback URL which it redirects back to.
+func followProvider(t *testing.T, ts *testServer, authURL string, tamper func(callback *url.URL)) (int, http.Header) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	rs, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+
+	callback, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(callback)
+	}
+
+	code, headers, _ := ts.get(t, callback.RequestURI())
+	return code, headers
+}
+
+func TestOIDCLogin(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		user         oidctest.User
+		tamper       func(callback *url.URL)
+		wantLocation string
+		wantFlash    string
+	}{
+		{
+			name:         "Existing user",
+			user:         oidctest.User{Subject: "1", Email: "falso@example.com", EmailVerified: true},
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Two-factor user",
+			user:         oidctest.User{Subject: "2", Email: "dos@example.com", EmailVerified: true},
+			wantLocation: "/user/login/2fa",
+		},
+		{
+			name:         "Unverified at provider",
+			user:         oidctest.User{Subject: "3", Email: "falso@example.com"},
+			wantLocation: "/user/login",
+			wantFlash:    "Your email address needs to be verified before you can use single sign-on.",
+		},
+		{
+			name:         "Unverified account",
+			user:         oidctest.User{Subject: "4", Email: "nuevo@example.com", EmailVerified: true},
+			wantLocation: "/user/login",
+			wantFlash:    "Your email address needs to be verified before you can use single sign-on.",
+		},
+		{
+			name: "Wrong state",
+			user: oidctest.User{Subject: "1", Email: "falso@example.com", EmailVerified: true},
+			tamper: func(callback *url.URL) {
+				q := callback.Query()
+				q.Set("state", "forged")
+				callback.RawQuery = q.Encode()
+			},
+			wantLocation: "/user/login",
+			wantFlash:    "Your single sign-on login failed. Please try again.",
+		},
+		{
+			name: "Wrong code",
+			user: oidctest.User{Subject: "1", Email: "falso@example.com", EmailVerified: true},
+			tamper: func(callback *url.URL) {
+				q := callback.Query()
+				q.Set("code", "forged")
+				callback.RawQuery = q.Encode()
+			},
+			wantLocation: "/user/login",
+			wantFlash:    "Your single sign-on login failed. Please try again.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			app.oidcProviders = []*oidc.Provider{
+				oidc.New(srv.Config("test"), ts.URL+"/user/login/oidc/test/callback", nil),
+			}
+			srv.SetUser(tt.user)
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, `<a href="/user/login/oidc/test">Log in with Test Provider</a>`)
+
+			code, headers := ssoLogin(t, ts, tt.tamper)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, "/user/login")
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginUnknownProvider(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/user/login/oidc/unknown")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// Check that the callback can't be used without starting a login first.
+	code, headers, _ := ts.get(t, "/user/login/oidc/unknown/callback?code=x&state=y")
+	assert.Equal(t, code, http.StatusNotFound)
+	assert.Equal(t, headers.Get("Location"), "")
+}
+
+// The newSSOTestServer helper starts a test server with the fake identity
+// provider configured, and logs in as the mock user whose account was created
+// by single sign-on, so hasn't got a password.
+func newSSOTestServer(t *testing.T, app *application, srv *oidctest.Server) *testServer {
+	ts := newTestServer(t, app.routes())
+
+	app.oidcProviders = []*oidc.Provider{
+		oidc.New(srv.Config("test"), ts.URL+"/user/login/oidc/test/callback", nil),
+	}
+	srv.SetUser(oidctest.User{Subject: mocks.SSOSubject, Email: "sso@example.com", EmailVerified: true})
+
+	code, headers := ssoLogin(t, ts, nil)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+	return ts
+}
+
+func TestAccountDeleteReauth(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		subject      string
+		modify       func(claims map[string]any)
+		wantLocation string
+		wantFlash    string
+	}{
+		{
+			name:         "Confirmed",
+			subject:      mocks.SSOSubject,
+			wantLocation: "/",
+			wantFlash:    "Your account has been deleted!",
+		},
+		{
+			name:         "Different identity",
+			subject:      "someone-else",
+			wantLocation: "/account/view",
+			wantFlash:    "We couldn&#39;t confirm that it&#39;s you. Please try again.",
+		},
+		{
+			name:    "Earlier login",
+			subject: mocks.SSOSubject,
+			modify: func(claims map[string]any) {
+				claims["auth_time"] = testTime.Add(-time.Hour).Unix()
+			},
+			wantLocation: "/account/view",
+			wantFlash:    "We couldn&#39;t confirm that it&#39;s you. Please try again.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newSSOTestServer(t, app, srv)
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/account/delete")
+			assert.StringContains(t, body, "You'll be asked to log in with Test Provider again to confirm that it's you.")
+
+			form := url.Values{}
+			form.Add("confirmation", "DELETE")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			// The user should be sent to the provider, which is asked to make
+			// them log in again.
+			code, headers, _ := ts.postForm(t, "/account/delete", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			authURL, err := url.Parse(headers.Get("Location"))
+			assert.NilError(t, err)
+			assert.Equal(t, authURL.Query().Get("prompt"), "login")
+
+			srv.SetUser(oidctest.User{Subject: tt.subject, Email: "sso@example.com", EmailVerified: true})
+			srv.Modify = tt.modify
+			defer func() { srv.Modify = nil }()
+
+			code, headers = followProvider(t, ts, authURL.String(), nil)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+
+			_, _, body = ts.get(t, "/")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+func TestAccountDeleteNoPassword(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	app := newTestApplication(t)
+	ts := newSSOTestServer(t, app, srv)
+	defer ts.Close()
+
+	// Without the provider that the account is linked to, the user has to
+	// set a password before they can confirm.
+	app.oidcProviders = nil
+
+	_, _, body := ts.get(t, "/account/delete")
+	assert.StringContains(t, body, `Please <a href="/user/password/forgot">reset your password</a> first`)
+
+	form := url.Values{}
+	form.Add("confirmation", "DELETE")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, body := ts.postForm(t, "/account/delete", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "reset your password")
+}
+
+func TestAccountEmailUpdateReauth(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	app := newTestApplication(t)
+	mail := &mailer.Memory{}
+	app.mailer = mail
+	app.auditLog = &mocks.AuditModel{}
+
+	ts := newSSOTestServer(t, app, srv)
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/account/email/update")
+	assert.StringContains(t, body, "You'll be asked to log in with Test Provider again to confirm that it's you.")
+
+	form := url.Values{}
+	form.Add("email", mocks.EmailChangeNewEmail)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, headers, _ := ts.postForm(t, "/account/email/update", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Nothing should be sent until the user has logged in again.
+	_, sent := mail.Last()
+	assert.Equal(t, sent, false)
+
+	code, headers = followProvider(t, ts, headers.Get("Location"), nil)
+	app.wg.Wait()
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	msg, sent := mail.Last()
+	assert.Equal(t, sent, true)
+	assert.Equal(t, msg.To, mocks.EmailChangeNewEmail)
 }
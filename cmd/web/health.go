@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Define how long each readiness check can take before it's counted as
+// failed.
+const readyCheckTimeout = 2 * time.Second
+
+// Define a checkResult type to hold the outcome of a readiness check, as
+// it's reported by /readyz.
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// The healthz handler reports that the process is up and able to handle
+// requests. It doesn't check any dependencies, so that a database outage
+// doesn't get every instance restarted.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, r, http.StatusOK, envelope{"status": "ok"})
+}
+
+// The readyz handler reports whether this instance should be sent traffic.
+// It checks that the database can be reached, that the session store works
+// and that the database schema is up to date, and sends a 503 Service
+// Unavailable response if any of them fails. Once a shutdown has started it
+// always reports that the instance isn't ready, so that load balancers stop
+// sending it requests before it stops accepting them.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if app.shuttingDown.Load() {
+		app.writeJSON(w, r, http.StatusServiceUnavailable, envelope{"status": "shutting_down"})
+		return
+	}
+
+	checks := map[string]checkResult{
+		"database":   runCheck(r.Context(), app.checkDatabase),
+		"sessions":   runCheck(r.Context(), app.checkSessionStore),
+		"migrations": runCheck(r.Context(), app.checkMigrations),
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			status, code = "unready", http.StatusServiceUnavailable
+		}
+	}
+
+	app.writeJSON(w, r, code, envelope{"status": status, "checks": checks})
+}
+
+// The runCheck() func runs a readiness check with a timeout, and records how
+// long it took. Checks can return a detail to report (ex: the schema
+// version) as well as an error.
+func runCheck(ctx context.Context, check func(ctx context.Context) (string, error)) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// Run the check in a goroutine, so that checks which don't take a
+	// context still give up after the timeout.
+	type outcome struct {
+		detail string
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		detail, err := check(ctx)
+		done <- outcome{detail, err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		o.err = ctx.Err()
+	}
+
+	result := checkResult{
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    o.detail,
+	}
+	if o.err != nil {
+		result.Status = "fail"
+		result.Error = o.err.Error()
+	}
+
+	return result
+}
+
+// The checkDatabase() method pings the database.
+func (app *application) checkDatabase(ctx context.Context) (string, error) {
+	return "", app.health.Ping(ctx)
+}
+
+// The checkSessionStore() method looks up a session which doesn't exist, to
+// check that the session store can be queried.
+func (app *application) checkSessionStore(ctx context.Context) (string, error) {
+	_, _, err := app.sessionManager.Store.Find("readyz")
+	return "", err
+}
+
+// The checkMigrations() method checks that all the migrations have been
+// applied to the database, and reports the schema version.
+func (app *application) checkMigrations(ctx context.Context) (string, error) {
+	status, err := app.health.MigrationStatus(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("version %d of %d", status.Version, status.Latest)
+
+	if !status.Current() {
+		return detail, fmt.Errorf("%d migrations pending", status.Pending)
+	}
+
+	return detail, nil
+}
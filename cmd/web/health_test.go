@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/models/mocks"
+)
+
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+	app.health = &mocks.HealthModel{PingErr: errors.New("connection refused")}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Check that the process is reported as up, even though the database
+	// isn't.
+	code, _, body := ts.get(t, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"status":"ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		health       *mocks.HealthModel
+		shuttingDown bool
+		wantCode     int
+		wantStatus   string
+		wantChecks   map[string]string
+		wantDetail   string
+		wantError    string
+	}{
+		{
+			name:       "Ready",
+			health:     &mocks.HealthModel{},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]string{"database": "ok", "sessions": "ok", "migrations": "ok"},
+			wantDetail: "version 11 of 11",
+		},
+		{
+			name:       "Database down",
+			health:     &mocks.HealthModel{PingErr: errors.New("connection refused")},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unready",
+			wantChecks: map[string]string{"database": "fail", "sessions": "ok", "migrations": "fail"},
+			wantError:  "connection refused",
+		},
+		{
+			name:       "Migrations pending",
+			health:     &mocks.HealthModel{Pending: 2},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unready",
+			wantChecks: map[string]string{"database": "ok", "sessions": "ok", "migrations": "fail"},
+			wantError:  "2 migrations pending",
+		},
+		{
+			name:         "Shutting down",
+			health:       &mocks.HealthModel{},
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantStatus:   "shutting_down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.health = tt.health
+			app.shuttingDown.Store(tt.shuttingDown)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, headers, body := ts.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Content-Type"), "application/json")
+
+			var rs struct {
+				Status string                 `json:"status"`
+				Checks map[string]checkResult `json:"checks"`
+			}
+			err := json.Unmarshal([]byte(body), &rs)
+			assert.NilError(t, err)
+
+			assert.Equal(t, rs.Status, tt.wantStatus)
+			assert.Equal(t, len(rs.Checks), len(tt.wantChecks))
+
+			for name, want := range tt.wantChecks {
+				assert.Equal(t, rs.Checks[name].Status, want)
+				assert.Equal(t, rs.Checks[name].LatencyMS >= 0, true)
+			}
+
+			if tt.wantDetail != "" {
+				assert.Equal(t, rs.Checks["migrations"].Detail, tt.wantDetail)
+			}
+
+			if tt.wantError != "" {
+				assert.StringContains(t, body, tt.wantError)
+			}
+		})
+	}
+}
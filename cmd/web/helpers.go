@@ -1,34 +1,56 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/highlight"
+	"github.com/Avixph/learn-go-snippetbox/internal/mailer"
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc"
+	"github.com/Avixph/learn-go-snippetbox/internal/validator"
+	"github.com/Avixph/learn-go-snippetbox/ui"
+	"github.com/boombuler/barcode/qr"
 	"github.com/go-playground/form/v4"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/nosurf"
 )
 
-// The serverError helper writes an error message and stack trace to the
-// errorLog, then sends a generic 500 Internal Server Error response to the
-// user.
-func (app *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.errorLog.Output(2, trace)
-
+// The serverError helper logs an error message and stack trace, along with
+// the details of the request, then sends a generic 500 Internal Server Error
+// response to the user.
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	trace := string(debug.Stack())
+	body := http.StatusText(http.StatusInternalServerError)
 	if app.debug {
-		http.Error(w, trace, http.StatusInternalServerError)
-		return
+		body = fmt.Sprintf("%s\n%s", err.Error(), trace)
 	}
 
-	ise := http.StatusInternalServerError
-	http.Error(w, http.StatusText(ise), ise)
+	app.logServerError(r, err, trace, len(body)+1)
+
+	http.Error(w, body, http.StatusInternalServerError)
 }
 
 // The clientError helper sends a specific status code and corresponding
@@ -45,6 +67,74 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, nf)
 }
 
+// Define the default number of snippets shown on a page, and the largest
+// page size that a client is allowed to ask for.
+const (
+	defaultPageSize = 10
+	maxPageSize     = 50
+)
+
+// The readPageParams() helper reads the "after" and "before" pagination
+// cursors and the "limit" page size from the query string. The page size
+// defaults to defaultPageSize and is capped at maxPageSize. An error is
+// returned if a value is malformed, or if both cursors are given.
+func readPageParams(r *http.Request) (after, before *models.Cursor, limit int, err error) {
+	qs := r.URL.Query()
+
+	if s := qs.Get("after"); s != "" {
+		c, err := models.ParseCursor(s)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		after = &c
+	}
+
+	if s := qs.Get("before"); s != "" {
+		c, err := models.ParseCursor(s)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		before = &c
+	}
+
+	if after != nil && before != nil {
+		return nil, nil, 0, errors.New("only one of after and before may be given")
+	}
+
+	limit = defaultPageSize
+
+	if s := qs.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return nil, nil, 0, fmt.Errorf("invalid page size %q", s)
+		}
+		limit = min(limit, maxPageSize)
+	}
+
+	return after, before, limit, nil
+}
+
+// The pageURLs() helper returns the URLs of the pages either side of the
+// given page. They keep the current path and any other query string
+// parameters (like the page size), and only swap the cursor. An empty string
+// is returned if there's no page in that direction.
+func pageURLs(r *http.Request, page *models.SnippetPage) (next, prev string) {
+	link := func(key string, c *models.Cursor) string {
+		if c == nil {
+			return ""
+		}
+
+		qs := r.URL.Query()
+		qs.Del("after")
+		qs.Del("before")
+		qs.Set(key, c.String())
+
+		return r.URL.Path + "?" + qs.Encode()
+	}
+
+	return link("after", page.Next), link("before", page.Prev)
+}
+
 // The getEnvVariables() helper reads the .env file and returns the requested
 // key value
 func getEnvVariables(key string) string {
@@ -56,14 +146,15 @@ func getEnvVariables(key string) string {
 	return os.Getenv(key)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 	// Retrieve the appropiate set from the cache based on the page name (ex:
 	// 'home.html'). If no entrry exists in the cache withthe provided name,
 	// then create a new err and call the serverError() helper method.
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, err)
+		app.metrics.renderErrors.Inc()
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -75,7 +166,8 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	// then return.
 	err := ts.ExecuteTemplate(buff, "base", data)
 	if err != nil {
-		app.serverError(w, err)
+		app.metrics.renderErrors.Inc()
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -101,6 +193,13 @@ func (app *application) newTemplateData(r *http.Request) *templateData {
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r),
+		// Add the ID of the authenticated user, so that templates can check
+		// whether they own the data they're displaying.
+		AuthenticatedUserID: app.authenticatedUserID(r),
+		// Add the supported languages for the snippet form's language select.
+		Languages: highlight.Languages(),
+		// Add the single sign-on providers for the login page.
+		LoginProviders: app.oidcProviders,
 	}
 }
 
@@ -143,3 +242,682 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// The emailVerified helper reports whether the authenticated user has
+// verified their email address.
+func (app *application) emailVerified(r *http.Request) (bool, error) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return user.EmailVerified(), nil
+}
+
+// Create an authenticatedUserID helper method, which returns the ID of the
+// user stored in the request context by the authenticate middleware. If the
+// request is not from an authenticated user uuid.Nil is returned instead.
+func (app *application) authenticatedUserID(r *http.Request) uuid.UUID {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(uuid.UUID)
+	if !ok {
+		return uuid.Nil
+	}
+	return id
+}
+
+// The getSnippet helper retrieves the unexpired snippet identified by the
+// "id" URL parameter. If the snippet doesn't exist a 404 Not Found response is
+// sent and the returned bool is false, in which case the calling handler
+// should return straight away.
+func (app *application) getSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// The ownedSnippet helper works like getSnippet, but also checks that the
+// snippet was created by the authenticated user. If it belongs to somebody
+// else a 403 Forbidden response is sent and the returned bool is false.
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	snippet, ok := app.getSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if snippet.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// The writeSnippetContent helper writes the content of a snippet to the
+// response as UTF-8 plain text, with the matching Content-Length header.
+func (app *application) writeSnippetContent(w http.ResponseWriter, snippet *models.Snippet) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(snippet.Content)))
+
+	w.Write([]byte(snippet.Content))
+}
+
+// Use the regexp.MustCompile() func to parse a pattern which matches any run
+// of characters that aren't allowed in a download filename.
+var filenameRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// The snippetFilename() func builds a download filename for a snippet from
+// its title and language (ex: "An old silent pond" written in Go becomes
+// "an-old-silent-pond.go"). If nothing usable is left of the title, the
+// filename falls back to "snippet".
+func snippetFilename(snippet *models.Snippet) string {
+	name := filenameRX.ReplaceAllString(strings.ToLower(snippet.Title), "-")
+	name = strings.Trim(name, "-")
+
+	if len(name) > 64 {
+		name = strings.TrimRight(name[:64], "-")
+	}
+	if name == "" {
+		name = "snippet"
+	}
+
+	return name + "." + highlight.Extension(snippet.Language)
+}
+
+// Define an envelope type for the JSON responses sent by our API. Wrapping
+// the data in a named top-level key (ex: {"snippet": {...}}) makes the
+// responses self-documenting and easy to extend later.
+type envelope map[string]any
+
+// The writeJSON helper encodes the given data as JSON and sends it with the
+// provided status code and the "Content-Type: application/json" header.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// Limit the size of the JSON request bodies accepted by the API to 1MB.
+const maxJSONBytes = 1_048_576
+
+// The readJSON helper decodes the JSON request body into dst. Unknown fields,
+// bodies larger than 1MB and bodies containing more than one JSON value are
+// rejected, and the returned error has a message which is safe to send back
+// to the client.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &typeError):
+			if typeError.Field != "" {
+				return fmt.Errorf("body contains the wrong JSON type for field %q", typeError.Field)
+			}
+			return fmt.Errorf("body contains the wrong JSON type (at character %d)", typeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	// Call Decode() again to make sure that the body only contained a single
+	// JSON value.
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// The apiError helper sends a JSON error response with the given status code
+// and message (ex: {"error": "the requested resource could not be found"}).
+func (app *application) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.writeJSON(w, r, status, envelope{"error": message})
+}
+
+// The apiServerError helper is the JSON equivalent of serverError. It logs the
+// error and stack trace, then sends a generic 500 Internal Server Error
+// response.
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	body := `{"error":"the server encountered a problem and could not process your request"}` + "\n"
+
+	app.logServerError(r, err, string(debug.Stack()), len(body))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(body))
+}
+
+// The logServerError helper logs an error which caused a 500 Internal Server
+// Error response, with the same details as the request's access log record.
+func (app *application) logServerError(r *http.Request, err error, trace string, bytes int) {
+	attrs := append(app.requestAttrs(r),
+		"status", http.StatusInternalServerError,
+		"bytes", bytes,
+		"latency", app.requestLatency(r),
+		"trace", trace,
+	)
+
+	app.logger.Error(err.Error(), attrs...)
+}
+
+// The requestAttrs helper returns the attributes which identify a request in
+// its log records: its ID, method and URI, and the ID of the user who made
+// it (if they're authenticated).
+func (app *application) requestAttrs(r *http.Request) []any {
+	attrs := []any{
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+	}
+
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		attrs = append(attrs, "request_id", info.ID)
+		if info.UserID != uuid.Nil {
+			attrs = append(attrs, "user_id", info.UserID.String())
+		}
+	}
+
+	return attrs
+}
+
+// The requestLatency helper returns how long ago the request started.
+func (app *application) requestLatency(r *http.Request) time.Duration {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return 0
+	}
+	return time.Since(info.Started)
+}
+
+// The apiValidationError helper sends a 422 Unprocessable Entity response
+// containing the field and non-field errors from a failed validator.
+func (app *application) apiValidationError(w http.ResponseWriter, r *http.Request, v validator.Validator) {
+	data := envelope{
+		"error":  "validation failed",
+		"fields": v.FieldErrors,
+	}
+	if len(v.NonFieldErrors) > 0 {
+		data["errors"] = v.NonFieldErrors
+	}
+
+	app.writeJSON(w, r, http.StatusUnprocessableEntity, data)
+}
+
+// The background helper runs fn in a new goroutine. Any panic in fn is
+// recovered and logged, rather than crashing the application, and the
+// goroutine is tracked by app.wg so that we can wait for it to finish.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprint(err), "trace", string(debug.Stack()))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// The sendEmail helper renders the "subject" and "body" templates from the
+// given file in the ui/email directory, and sends the result to the given
+// address in the background. Any error sending the email is logged.
+func (app *application) sendEmail(to, file string, data any) error {
+	ts, err := texttemplate.ParseFS(ui.Files, "email/"+file)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(body, "body", data)
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      to,
+		Subject: subject.String(),
+		Body:    body.String(),
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(msg)
+		if err != nil {
+			app.logger.Error("sending email", "error", err)
+		}
+	})
+
+	return nil
+}
+
+// The oidcProvider helper returns the single sign-on provider with the
+// given name.
+func (app *application) oidcProvider(name string) (*oidc.Provider, bool) {
+	for _, p := range app.oidcProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// The recordAudit helper adds an event to a user's audit trail, along with
+// the IP address that the request came from.
+func (app *application) recordAudit(r *http.Request, userID uuid.UUID, action, details string) error {
+	return app.auditLog.Record(userID, action, details, remoteIP(r))
+}
+
+// The destroyUserSessions helper destroys every session belonging to the
+// given user, except for the session with the keep token (which can be
+// empty). We use it to log a user out of their other devices.
+func (app *application) destroyUserSessions(ctx context.Context, userID uuid.UUID, keep string) error {
+	return app.sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if app.sessionManager.GetString(ctx, "authenticatedUserID") != userID.String() {
+			return nil
+		}
+		if keep != "" && app.sessionManager.Token(ctx) == keep {
+			return nil
+		}
+
+		return app.sessionManager.Destroy(ctx)
+	})
+}
+
+// Define an activeSession type to hold the metadata about one of a user's
+// sessions, for showing on the active sessions page.
+type activeSession struct {
+	ID         string
+	UserAgent  string
+	IPAddress  string
+	CreatedOn  time.Time
+	LastSeenOn time.Time
+	Current    bool
+}
+
+// The sessionID() func returns an identifier for the session with the given
+// token. We never show the session token itself in a page, because anybody
+// who learnt it could hijack the session, so we use a truncated SHA-256 hash
+// of it instead.
+func sessionID(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:8])
+}
+
+// The recordSessionStart helper stores the metadata about a session which
+// is shown on the active sessions page. It's called when a user logs in.
+func (app *application) recordSessionStart(r *http.Request) {
+	now := app.clock().Unix()
+
+	app.sessionManager.Put(r.Context(), "userAgent", r.UserAgent())
+	app.sessionManager.Put(r.Context(), "ipAddress", remoteIP(r))
+	app.sessionManager.Put(r.Context(), "createdOn", now)
+	app.sessionManager.Put(r.Context(), "lastSeenOn", now)
+}
+
+// The remoteIP() func returns the IP address of the client which made the
+// request, without the port number.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// The sessionTime helper returns a time stored in the session as a Unix
+// timestamp. If there's no such value, the zero time is returned (sessions
+// which were created before we started recording their metadata don't have
+// one).
+func (app *application) sessionTime(ctx context.Context, key string) time.Time {
+	unix := app.sessionManager.GetInt64(ctx, key)
+	if unix == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(unix, 0)
+}
+
+// The userSessions helper returns the sessions belonging to the given user,
+// most recently used first. The session with the current token is marked as
+// the current one.
+func (app *application) userSessions(ctx context.Context, userID uuid.UUID, current string) ([]activeSession, error) {
+	var sessions []activeSession
+
+	err := app.sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if app.sessionManager.GetString(ctx, "authenticatedUserID") != userID.String() {
+			return nil
+		}
+
+		token := app.sessionManager.Token(ctx)
+
+		sessions = append(sessions, activeSession{
+			ID:         sessionID(token),
+			UserAgent:  app.sessionManager.GetString(ctx, "userAgent"),
+			IPAddress:  app.sessionManager.GetString(ctx, "ipAddress"),
+			CreatedOn:  app.sessionTime(ctx, "createdOn"),
+			LastSeenOn: app.sessionTime(ctx, "lastSeenOn"),
+			Current:    token == current,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenOn.After(sessions[j].LastSeenOn)
+	})
+
+	return sessions, nil
+}
+
+// The destroyUserSession helper destroys the session with the given ID, as
+// long as it belongs to the given user. It returns the ErrNoRecord error if
+// there is no such session.
+func (app *application) destroyUserSession(ctx context.Context, userID uuid.UUID, id string) error {
+	found := false
+
+	err := app.sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if app.sessionManager.GetString(ctx, "authenticatedUserID") != userID.String() {
+			return nil
+		}
+		if sessionID(app.sessionManager.Token(ctx)) != id {
+			return nil
+		}
+
+		found = true
+		return app.sessionManager.Destroy(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// The loginAttempt helper counts a login attempt for the given email address
+// before the credentials are checked, and returns how much longer logins for
+// the account, or from the client's IP address, are locked for. It returns
+// zero if the attempt can go ahead. Counting the attempt up front means that
+// logins sent at the same time can't all be checked before the failures are
+// recorded.
+func (app *application) loginAttempt(r *http.Request, email string) (time.Duration, error) {
+	now := app.clock()
+
+	ip, err := app.ipLimiter.Check(remoteIP(r), now)
+	if err != nil {
+		return 0, err
+	}
+	if ip > 0 {
+		return ip, nil
+	}
+
+	return app.accountLimiter.Attempt(strings.ToLower(email), now)
+}
+
+// The loginFailed helper records a failed login from the client's IP address
+// (the attempt for the email address has already been counted by
+// loginAttempt), and returns how long logins are now locked for.
+func (app *application) loginFailed(r *http.Request, email string) (time.Duration, error) {
+	app.metrics.failedLogins.Inc()
+
+	now := app.clock()
+
+	ip, err := app.ipLimiter.Fail(remoteIP(r), now)
+	if err != nil {
+		return 0, err
+	}
+
+	account, err := app.accountLimiter.Check(strings.ToLower(email), now)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(account, ip), nil
+}
+
+// The loginSucceeded helper forgets the login attempts for the given email
+// address. The failures from the IP address are kept, so that an attacker
+// can't reset their count by logging in to an account of their own.
+func (app *application) loginSucceeded(email string) error {
+	return app.accountLimiter.Reset(strings.ToLower(email))
+}
+
+// The loginPasswordAccepted helper takes back the login attempt counted for
+// a correct password, when the user still has to enter an authentication
+// code. Their earlier failures are kept, so that knowing the password
+// doesn't reset the lockout for guessing codes.
+func (app *application) loginPasswordAccepted(email string) error {
+	return app.accountLimiter.Cancel(strings.ToLower(email), app.clock())
+}
+
+// The reauthAttempt helper counts an attempt by the given user to confirm
+// who they are (ex: with an authentication code before turning off
+// two-factor authentication), and returns how much longer they are locked
+// out for, or zero if the attempt can go ahead. It uses the account limiter
+// keyed by the user's ID, so that somebody with access to a logged in
+// session can't guess the user's credentials without limit.
+func (app *application) reauthAttempt(userID uuid.UUID) (time.Duration, error) {
+	return app.accountLimiter.Attempt(userID.String(), app.clock())
+}
+
+// The reauthFailed helper returns how long the given user is locked out for
+// after a failed attempt to confirm who they are. The attempt has already
+// been counted by reauthAttempt.
+func (app *application) reauthFailed(userID uuid.UUID) (time.Duration, error) {
+	return app.accountLimiter.Check(userID.String(), app.clock())
+}
+
+// The reauthSucceeded helper forgets the attempts by the given user to
+// confirm who they are.
+func (app *application) reauthSucceeded(userID uuid.UUID) error {
+	return app.accountLimiter.Reset(userID.String())
+}
+
+// The lockoutMessage() func returns the error message shown to a user whose
+// logins are locked for the given duration.
+func lockoutMessage(d time.Duration) string {
+	return "Too many failed login attempts. " + tryAgainIn(d)
+}
+
+// The reauthLockoutMessage() func returns the error message shown to a user
+// who is locked out of confirming who they are for the given duration.
+func reauthLockoutMessage(d time.Duration) string {
+	return "Too many incorrect attempts. " + tryAgainIn(d)
+}
+
+// The setRetryAfter() func sets the Retry-After header to the given
+// duration, rounded up to the next second.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// The tryAgainIn() func tells the user how long to wait, rounded up to the
+// next minute.
+func tryAgainIn(d time.Duration) string {
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes <= 1 {
+		return "Please try again in a minute."
+	}
+
+	return fmt.Sprintf("Please try again in %d minutes.", minutes)
+}
+
+// The qrCodeSVG() func encodes the content as a QR code, and returns it as an
+// inline SVG image. Using inline SVG (rather than a PNG data URI) means the
+// image isn't blocked by our Content-Security-Policy.
+func qrCodeSVG(content string) (template.HTML, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return "", err
+	}
+
+	size := code.Bounds().Dx()
+
+	// Draw each dark module as a 1x1 square in a single path, and leave a
+	// quiet zone of 4 modules around the code, as required by the QR spec.
+	var path strings.Builder
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c, _, _, _ := code.At(x, y).RGBA(); c == 0 {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="-4 -4 %[1]d %[1]d" width="200" height="200" shape-rendering="crispEdges">`+
+		`<rect x="-4" y="-4" width="%[1]d" height="%[1]d" fill="#fff"/><path d="%[2]s" fill="#000"/></svg>`, size+8, path.String())
+
+	return template.HTML(svg), nil
+}
+
+// Define an exportProfile type which holds the JSON representation of a
+// user's profile in their data export.
+type exportProfile struct {
+	ID              uuid.UUID  `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	CreatedOn       time.Time  `json:"created_on"`
+	EmailVerifiedOn *time.Time `json:"email_verified_on"`
+	ExportedOn      time.Time  `json:"exported_on"`
+}
+
+// The writeAccountExport() func writes a user's data export to w as a ZIP
+// file. It contains a profile.json file with the user's details and a
+// snippets.json file with their snippets, in the same format as the API.
+func writeAccountExport(w io.Writer, export *models.UserExport, now time.Time) error {
+	profile := exportProfile{
+		ID:              export.User.ID,
+		Name:            export.User.Name,
+		Email:           export.User.Email,
+		CreatedOn:       export.User.CreatedOn,
+		EmailVerifiedOn: export.User.EmailVerifiedOn,
+		ExportedOn:      now,
+	}
+
+	snippets := make([]apiSnippet, 0, len(export.Snippets))
+	for _, s := range export.Snippets {
+		snippets = append(snippets, newAPISnippet(s))
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", profile},
+		{"snippets.json", snippets},
+	}
+
+	zw := zip.NewWriter(w)
+
+	for _, file := range files {
+		js, err := json.MarshalIndent(file.data, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(append(js, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// Define a reauthOptions struct, which is embedded in the forms for account
+// changes that need the user to confirm who they are. NoPassword is true if
+// the user hasn't set a password of their own (because their account was
+// created by single sign-on), and ReauthProvider is the configured identity
+// provider that they can log in with again instead, if there is one.
+type reauthOptions struct {
+	NoPassword     bool
+	ReauthProvider *oidc.Provider
+}
+
+// The setReauthOptions helper fills in the reauthOptions for the given user.
+func (app *application) setReauthOptions(opts *reauthOptions, user *models.User) error {
+	if user.PasswordSet {
+		return nil
+	}
+
+	opts.NoPassword = true
+
+	identities, err := app.users.Identities(user.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		if provider, ok := app.oidcProvider(identity.Provider); ok {
+			opts.ReauthProvider = provider
+			return nil
+		}
+	}
+
+	return nil
+}
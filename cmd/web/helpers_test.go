@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+)
+
+func TestSnippetFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		language string
+		want     string
+	}{
+		{
+			name:     "Simple title",
+			title:    "An old silent pond",
+			language: "go",
+			want:     "an-old-silent-pond.go",
+		},
+		{
+			name:     "Punctuation",
+			title:    "  Backup script: v2 (final!)  ",
+			language: "bash",
+			want:     "backup-script-v2-final.sh",
+		},
+		{
+			name:     "Nothing usable",
+			title:    "¿¡!?",
+			language: "plaintext",
+			want:     "snippet.txt",
+		},
+		{
+			name:     "Long title",
+			title:    strings.Repeat("a", 70),
+			language: "sql",
+			want:     strings.Repeat("a", 64) + ".sql",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &models.Snippet{Title: tt.title, Language: tt.language}
+
+			assert.Equal(t, snippetFilename(s), tt.want)
+		})
+	}
+}
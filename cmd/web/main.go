@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
 	"flag"
+	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/highlight"
+	"github.com/Avixph/learn-go-snippetbox/internal/lockout"
+	"github.com/Avixph/learn-go-snippetbox/internal/mailer"
+	"github.com/Avixph/learn-go-snippetbox/internal/migrations"
 	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc"
+	"github.com/Avixph/learn-go-snippetbox/internal/password"
+	"github.com/Avixph/learn-go-snippetbox/internal/validator"
 	"github.com/alexedwards/scs/postgresstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
@@ -23,17 +35,69 @@ import (
 // make the SnippetModel object available to our handlers.
 // Addd a templateCache feild, formDecoder field, a sessionManager field,
 // a users field and a debug field to the application struct.
+// Add a highlights field to cache the syntax highlighted snippet content.
+// Add a mailer field and the baseURL used to build links in emails, and a
+// WaitGroup to keep track of the background goroutines which send them.
+// Add a clock func, which returns the current time. Two-factor codes depend
+// on the time, so tests replace it with a fixed clock.
+// Add the lockouts store and the limiters which use it to slow down repeated
+// failed logins for an account, or from an IP address.
+// Add the policy which new passwords are checked against.
+// Add the policy for what happens to a user's snippets when they delete their
+// account.
+// Add the auditLog field, which records changes made to users' accounts.
+// Add the single sign-on providers which users can log in with.
+// Add the Prometheus metrics, which are served on the admin listener.
+// Add the health field used by the readiness checks, and the shuttingDown
+// flag which makes /readyz fail once a shutdown starts.
 type application struct {
 	debug          bool
-	errorLog       *log.Logger
-	infoLog        *log.Logger
+	logger         *slog.Logger
 	snippets       models.SnippetModelInterface
 	users          models.UserModelInterface
+	tokens         models.TokenModelInterface
+	passwordResets models.PasswordResetModelInterface
+	verifications  models.EmailVerificationModelInterface
+	twoFactor      models.TwoFactorModelInterface
+	auditLog       models.AuditModelInterface
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	highlights     *highlight.Cache
+	mailer         mailer.Mailer
+	baseURL        string
+	wg             sync.WaitGroup
+	clock          func() time.Time
+	lockouts       lockout.Store
+	accountLimiter *lockout.Limiter
+	ipLimiter      *lockout.Limiter
+	passwordPolicy *validator.PasswordPolicy
+	snippetPolicy  models.SnippetPolicy
+	oidcProviders  []*oidc.Provider
+	metrics        *metrics
+	health         models.HealthModelInterface
+	shuttingDown   atomic.Bool
 }
 
+// Define the policies for failed logins. An account is locked for 30 seconds
+// after 5 failed logins in a row, doubling with each further failure up to
+// 15 minutes. IP addresses get more attempts, because many users can share
+// one address, but are locked for up to an hour.
+var (
+	accountLoginPolicy = lockout.Policy{
+		Threshold:  5,
+		BaseDelay:  30 * time.Second,
+		MaxDelay:   15 * time.Minute,
+		ResetAfter: time.Hour,
+	}
+	ipLoginPolicy = lockout.Policy{
+		Threshold:  20,
+		BaseDelay:  30 * time.Second,
+		MaxDelay:   time.Hour,
+		ResetAfter: time.Hour,
+	}
+)
+
 func main() {
 	// Define a new comand-line flag with the name 'addr', a default value of
 	// ":4000" and some short help text explaining what the flag controls.
@@ -47,6 +111,47 @@ func main() {
 	// Define a new debug flag with a defualt value of false.
 	debug := flag.Bool("debug", false, "Enable debug mode")
 
+	// Define the flags used to send emails and build the links in them. If no
+	// SMTP server address is given, emails are written to files in the mail
+	// directory instead.
+	baseURL := flag.String("base-url", getEnvVariables("BASE_URL"), "Public base URL used in email links")
+	smtpAddr := flag.String("smtp-addr", getEnvVariables("SMTP_ADDR"), "SMTP server address (host:port)")
+	smtpUsername := flag.String("smtp-username", getEnvVariables("SMTP_USERNAME"), "SMTP username")
+	smtpPassword := flag.String("smtp-password", getEnvVariables("SMTP_PASSWORD"), "SMTP password")
+	mailSender := flag.String("mail-sender", "Snippetbox <no-reply@snippetbox.local>", "Sender address for emails")
+	mailDir := flag.String("mail-dir", "./tmp/mail", "Directory to write emails to when no SMTP server is set")
+
+	// Define a flag for the directory of breached password hashes which new
+	// passwords are checked against. If it's empty the check is skipped.
+	breachedPasswords := flag.String("breached-passwords", getEnvVariables("BREACHED_PASSWORDS"), "Directory of SHA-1 hashes of breached passwords, one file per 5 character prefix")
+
+	// Define a flag for what happens to a user's snippets when they delete
+	// their account: "delete" removes them, and "anonymize" keeps them
+	// published without the user's name.
+	deletedSnippets := flag.String("deleted-snippets", getEnvVariables("DELETED_SNIPPETS"), "What to do with a deleted user's snippets (delete|anonymize)")
+
+	// Define a flag for the JSON file which lists the OpenID Connect
+	// providers that users can log in with. If it's empty single sign-on is
+	// turned off.
+	oidcProviders := flag.String("oidc-providers", getEnvVariables("OIDC_PROVIDERS"), "JSON file listing OpenID Connect providers")
+
+	// Define a flag for how long to wait for in-flight requests and
+	// background tasks to finish when shutting down.
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Time to wait for requests to finish when shutting down")
+
+	// Define a flag for how long to keep serving requests after /readyz
+	// starts failing at the start of a shutdown, so that load balancers can
+	// stop sending us traffic first.
+	shutdownDelay := flag.Duration("shutdown-delay", 5*time.Second, "Time to keep serving after becoming unready when shutting down")
+
+	// Define a flag for the address of the admin listener, which serves the
+	// metrics. It should only be reachable from inside our network.
+	adminAddr := flag.String("admin-addr", getEnvVariables("ADMIN_ADDR"), "Admin HTTP network address (serves /metrics)")
+
+	// Define a flag for the format of the logs: "json" (the default) for our
+	// log pipeline, or "text" which is easier to read in development.
+	logFormat := flag.String("log-format", getEnvVariables("LOG_FORMAT"), "Log format (json|text)")
+
 	// Importantly, we use the flag.Parse() func to parse the command-line
 	// flag. This reads in the command-line flag value and assigns it to the
 	// addr var. We need to call this *before* using the addr var
@@ -54,38 +159,111 @@ func main() {
 	// errs are encounted during parsing the application will be terminated.
 	flag.Parse()
 
-	// Use log.New() to create a logger for writting info messages. This
-	// takes three parameters: the destination to wrie the logs to (os.
-	// Stdout), a string prefix for message (INFO followed by tab), and
-	// flags to indicate what additional info ito include (local data and
-	// time). Note thatthe flags ae joined using the bitwise OR operator |.
-	// Create a logger for writting error messages in the same way, but use
-	// stderr as the destination and use the log.Lshortfile to include the
-	// relevant file name and line number.
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "Error\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// Default the base URL to the local server address.
+	if *baseURL == "" {
+		*baseURL = "https://localhost" + *addr
+	}
+
+	// Default the admin listener to the loopback interface.
+	if *adminAddr == "" {
+		*adminAddr = "localhost:4001"
+	}
+
+	// Default to deleting the snippets of deleted users.
+	if *deletedSnippets == "" {
+		*deletedSnippets = string(models.DeleteSnippets)
+	}
+
+	// Use the newLogger() func to create a structured logger which writes
+	// to stdout in the format chosen with the -log-format flag. In debug
+	// mode debug messages are logged too.
+	logger, err := newLogger(os.Stdout, *logFormat, *debug)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Check the snippet policy before connecting to the database, so that a
+	// typo is caught straight away.
+	snippetPolicy := models.SnippetPolicy(*deletedSnippets)
+	if snippetPolicy != models.DeleteSnippets && snippetPolicy != models.AnonymizeSnippets {
+		logger.Error(fmt.Sprintf("invalid -deleted-snippets value %q (must be delete or anonymize)", *deletedSnippets))
+		os.Exit(1)
+	}
 
 	// To keep the main() func tidy the code for creating a connection pool was
 	// placed into a seperate openDB() func. WE pass opewnDB() the DSN from the
 	// command-line flag.
 	db, err := openDB(*dsn)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// The connection pool is closed by app.shutdown() once the server has
+	// stopped.
+
+	// Load the database migrations which are embedded in the binary.
+	migrator, err := migrations.New(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	// We also defer a call to the db.Close(), so that the connection pool is
-	// closed before the main() func exits.
-	defer db.Close()
+	// If the "migrate" subcommand was given (ex: "web migrate up"), run it
+	// instead of starting the server.
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(context.Background(), migrator, logger, os.Stdout, flag.Args()[1:])
+		db.Close()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Initialize a new template cache.
 	templateCache, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
 	// Initialize a decoder instance.
 	formDecoder := form.NewDecoder()
 
+	// Open the breached passwords corpus, if there is one. Its buckets are
+	// read as passwords are checked, rather than all at once.
+	var breached *validator.BreachedPasswords
+	if *breachedPasswords != "" {
+		breached, err = validator.OpenBreachedPasswords(*breachedPasswords)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("checking passwords against breached password hashes", "dir", *breachedPasswords)
+	}
+
+	// Load the single sign-on providers, if there are any. Users are sent
+	// back to a callback URL for each provider after logging in, which needs
+	// to be registered with the provider.
+	var providers []*oidc.Provider
+	if *oidcProviders != "" {
+		configs, err := oidc.LoadConfigs(*oidcProviders)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		client := &http.Client{Timeout: 10 * time.Second}
+
+		for _, c := range configs {
+			redirectURL := fmt.Sprintf("%s/user/login/oidc/%s/callback", *baseURL, c.Name)
+			providers = append(providers, oidc.New(c, redirectURL, client))
+			logger.Info("single sign-on enabled", "provider", c.Name, "callback_url", redirectURL)
+		}
+	}
+
 	// Initialize a new session manager with scs.New() funct. Then we configure
 	// it touse oour PostgeSQL database as the session store, and set a lifetime
 	// of 12 hours (so that sessions automatically expire after 12 hours of
@@ -94,11 +272,16 @@ func main() {
 	// Setting ths means that the cookie will only be sent by a user's web
 	// browser when HTTPS connection is being used (and wo't be sent over
 	// unsecure HTTP connections).
+	sessionStore := postgresstore.New(db)
 	sessionManager := scs.New()
-	sessionManager.Store = postgresstore.New(db)
+	sessionManager.Store = sessionStore
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	// Keep track of failed logins in memory. This is lost on restart, but
+	// means that a single server doesn't need an extra store.
+	lockouts := lockout.NewMemory()
+
 	// Initialize a new instance of our application struct, containing the
 	// dependencies.
 	// Initialize a models.SnippetModel instance and add it to the
@@ -107,13 +290,29 @@ func main() {
 	// UserModel, and a debug to the application dependencies.
 	app := &application{
 		debug:          *debug,
-		errorLog:       errorLog,
-		infoLog:        infoLog,
+		logger:         logger,
 		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		users:          &models.UserModel{DB: db, Hasher: password.DefaultArgon2id},
+		tokens:         &models.TokenModel{DB: db},
+		passwordResets: &models.PasswordResetModel{DB: db, Hasher: password.DefaultArgon2id},
+		verifications:  &models.EmailVerificationModel{DB: db},
+		twoFactor:      &models.TwoFactorModel{DB: db},
+		auditLog:       &models.AuditModel{DB: db},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		highlights:     highlight.NewCache(32 << 20),
+		mailer:         newMailer(*smtpAddr, *smtpUsername, *smtpPassword, *mailSender, *mailDir),
+		baseURL:        *baseURL,
+		clock:          time.Now,
+		lockouts:       lockouts,
+		accountLimiter: lockout.New(lockouts, "account", accountLoginPolicy),
+		ipLimiter:      lockout.New(lockouts, "ip", ipLoginPolicy),
+		passwordPolicy: &validator.PasswordPolicy{MinChars: 16, Breached: breached},
+		snippetPolicy:  snippetPolicy,
+		oidcProviders:  providers,
+		metrics:        newMetrics(db),
+		health:         &models.HealthModel{DB: db, Migrator: migrator},
 	}
 
 	// Initialize a tls.Config struct to hold the non-default TLS settings we
@@ -126,15 +325,15 @@ func main() {
 
 	// Initalize a new http.Server struct. We set the Addr and Handler
 	// fields so that the server uses the same network address and routes as
-	// before, and set the ErrorLog fielfd so that ther server now uses the
-	// custom errorLog logger in the event of and problems.
+	// before, and set the ErrorLog field so that the server's own errors are
+	// written by our structured logger at the error level.
 	// Call the new app.routes() method to get the servermux containing our
 	// routes.
 	// Set the server's TLSConfig field to use the tlsConfig variable.
 	// Add Idle, Read Write timeouts to the server.
 	srv := &http.Server{
 		Addr:         *addr,
-		ErrorLog:     errorLog,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:      app.routes(),
 		TLSConfig:    tlsConfig,
 		IdleTimeout:  time.Minute,
@@ -142,23 +341,51 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	// The value returned from the flag.Sring() func is a pointer to the
-	// flag value, not the value itself. So we need to dereference the
-	// pointer (i.e. prefix it with the * symbol) before using it. Note that
-	// we're using the infoLog.Printf() func to interpolate the address with
-	// the log message.
-	// Use the http.ListenAndServeTLS() func on the http.Server() struct to
-	// start a new web server (passing in the paths to the TLS certificate and
-	//corresponding private key). We pass in two parameters: the TCP network
-	// address to listen on (ex:(localhost::4000)) and the servermux we
-	// created. If http. listenAndServe() returns an err we use the errorLog.
-	// Fatal() func to log the err message and exit. Note that any err
-	// returned by http. listenAndServe() is always non-nill.
-	// Because the err var is already declared above, we need to use the
-	// assignment operator "=" here, instead of ":=" 'declare and assigng'
-	infoLog.Printf("Starting server on https://localhost%s", *addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	// Initialize a second http.Server for the admin listener. It uses plain
+	// HTTP, since it's only reachable from inside our network.
+	adminSrv := &http.Server{
+		Addr:         *adminAddr,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		Handler:      app.adminRoutes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	// Use the app.serve() method to start the servers (passing in the paths
+	// to the TLS certificate and corresponding private key). It blocks until
+	// a server fails to start, or they're shut down by a SIGINT or SIGTERM
+	// signal, in which case in-flight requests are drained and the
+	// background tasks, session store and connection pool are stopped before
+	// it returns.
+	err = app.serve(srv, adminSrv, "./tls/cert.pem", "./tls/key.pem", shutdownConfig{
+		delay:   *shutdownDelay,
+		timeout: *shutdownTimeout,
+		store:   sessionStore,
+		db:      db,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// The newLogger() func returns a logger which writes JSON or text records to
+// w. If debug is true debug messages are logged as well.
+func newLogger(w io.Writer, format string, debug bool) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if debug {
+		opts.Level = slog.LevelDebug
+	}
+
+	switch format {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format value %q (must be json or text)", format)
+	}
 }
 
 // The openDB() func wraps sql.Open() and returns a sql.DB connection pool for
@@ -176,17 +403,17 @@ func openDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-// CREATE DATABASE test_snippetbox WITH ENCODING 'UTF8' LC_COLLATE='en_US.UTF-8' LC_CTYPE='en_US.UTF-8' TEMPLATE=template0;
-
-// CREATE USER test_web WITH PASSWORD 'learn-go-snippetbox';
-// // GRANT CREATE, SELECT, INSERT, UPDATE, DELETE ON DATABASE test_snippetbox TO test_web;
-// GRANT ALL ON DATABASE test_snippetbox TO test_web;
-// REVOKE TEMPORARY ON DATABASE test_snippetbox FROM test_web;
+// The newMailer() func returns an SMTP mailer if an SMTP server address is
+// given, and a mailer which writes emails to files in mailDir if not.
+func newMailer(smtpAddr, username, password, sender, mailDir string) mailer.Mailer {
+	if smtpAddr == "" {
+		return &mailer.File{Dir: mailDir, Sender: sender}
+	}
 
-// INSERT INTO snippets (title, content, created_on, updated_on, expires_on) VALUES (
-//     'First autumn morning',
-//     'First autumn morning\nthe mirror I stare into\nshows my father''s face.\n\n– Murakami Kijo',
-//     (now() at time zone 'utc'),
-//     (now() at time zone 'utc'),
-//                 (now() at time zone 'utc' + interval '7 day')
-// );
+	return &mailer.SMTP{
+		Addr:     smtpAddr,
+		Username: username,
+		Password: password,
+		Sender:   sender,
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Define a metrics type to hold the Prometheus metrics which the application
+// updates itself. The database pool and Go runtime metrics are collected
+// when they're scraped instead.
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	snippetsCreated prometheus.Counter
+	signups         prometheus.Counter
+	failedLogins    prometheus.Counter
+	renderErrors    prometheus.Counter
+}
+
+// The newMetrics() func creates the application's metrics in a new registry.
+// If db isn't nil, the connection pool's stats from db.Stats() are included.
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "Number of HTTP requests handled, by route pattern and response status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		snippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Number of snippets created.",
+		}),
+		signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_signups_total",
+			Help: "Number of users who have signed up.",
+		}),
+		failedLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_failed_logins_total",
+			Help: "Number of failed login attempts, including wrong two-factor codes.",
+		}),
+		renderErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_template_render_errors_total",
+			Help: "Number of pages which failed to render.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.snippetsCreated,
+		m.signups,
+		m.failedLogins,
+		m.renderErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+	}
+
+	return m
+}
+
+// Define the request methods which get their own label value. Any other
+// method is counted as "OTHER".
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// The observeRequest() method records a handled request. Requests which
+// didn't match a route (ex: 404 Not Found responses) share the "unmatched"
+// route, and non-standard methods share the "OTHER" method, so that
+// scanners can't create a new time series for every path or method they
+// try.
+func (m *metrics) observeRequest(method, route string, status int, latency time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	if !metricMethods[method] {
+		method = "OTHER"
+	}
+
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(method, route).Observe(latency.Seconds())
+}
+
+// The handler() method returns a handler which serves the metrics in the
+// Prometheus text exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Define a patternRouter type which wraps httprouter.Router, and records the
+// pattern of the route which matched each request (ex: "/snippet/view/:id")
+// in the request's requestInfo. The metrics are labelled with the pattern
+// rather than the path, which would give every snippet its own time series.
+type patternRouter struct {
+	*httprouter.Router
+}
+
+func (pr *patternRouter) Handler(method, path string, handler http.Handler) {
+	pr.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.Route = path
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func (pr *patternRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	pr.Handler(method, path, handler)
+}
+
+// The adminRoutes() method returns the handler for the admin listener, which
+// isn't exposed to the internet.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metrics.handler())
+
+	return mux
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// The scrapeMetrics helper returns the metrics page from the admin routes.
+func scrapeMetrics(t *testing.T, app *application) string {
+	rr := httptest.NewRecorder()
+
+	r, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.adminRoutes().ServeHTTP(rr, r)
+
+	rs := rr.Result()
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestRequestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/snippet/view/6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	ts.get(t, "/snippet/view/6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	ts.get(t, "/snippet/view/a1b2c3d4-0000-0000-0000-000000000000")
+	ts.get(t, "/wp-login.php")
+
+	body := scrapeMetrics(t, app)
+
+	// Check that the requests are counted by route pattern rather than by
+	// path, and that unknown paths share one series.
+	assert.StringContains(t, body, `snippetbox_http_requests_total{method="GET",route="/snippet/view/:id",status="200"} 2`)
+	assert.StringContains(t, body, `snippetbox_http_requests_total{method="GET",route="/snippet/view/:id",status="404"} 1`)
+	assert.StringContains(t, body, `snippetbox_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.StringContains(t, body, `snippetbox_http_request_duration_seconds_count{method="GET",route="/snippet/view/:id"} 3`)
+}
+
+func TestRequestMetricsMethods(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, method := range []string{"FOOBAR", "PROPFIND", http.MethodDelete} {
+		req, err := http.NewRequest(method, ts.URL+"/wp-login.php", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+	}
+
+	body := scrapeMetrics(t, app)
+
+	// Check that the non-standard methods share one series.
+	assert.StringContains(t, body, `snippetbox_http_request_duration_seconds_count{method="OTHER",route="unmatched"} 2`)
+	assert.StringContains(t, body, `snippetbox_http_request_duration_seconds_count{method="DELETE",route="unmatched"} 1`)
+	assert.Equal(t, strings.Contains(body, `method="FOOBAR"`), false)
+	assert.Equal(t, strings.Contains(body, `method="PROPFIND"`), false)
+}
+
+func TestBusinessMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Sign up a new user.
+	_, _, body := ts.get(t, "/user/signup")
+
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "1376p@$$w0rd8923")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	app.wg.Wait()
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Fail to log in twice.
+	for i := 0; i < 2; i++ {
+		_, _, body = ts.get(t, "/user/login")
+
+		form = url.Values{}
+		form.Add("email", "falso@example.com")
+		form.Add("password", "wrong password")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, _ = ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// Log in and create a snippet.
+	ts.login(t, "falso@example.com", "1376p@$$w0rd8923")
+
+	_, _, body = ts.get(t, "/snippet/create")
+
+	form = url.Values{}
+	form.Add("title", "O snail")
+	form.Add("content", "O snail\nClimb Mount Fuji,\nBut slowly, slowly!")
+	form.Add("language", "plaintext")
+	form.Add("expires", "365")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ = ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Render a page which doesn't exist.
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.render(httptest.NewRecorder(), r, http.StatusOK, "missing.html", &templateData{})
+
+	assert.Equal(t, testutil.ToFloat64(app.metrics.signups), 1)
+	assert.Equal(t, testutil.ToFloat64(app.metrics.failedLogins), 2)
+	assert.Equal(t, testutil.ToFloat64(app.metrics.snippetsCreated), 1)
+	assert.Equal(t, testutil.ToFloat64(app.metrics.renderErrors), 1)
+
+	body = scrapeMetrics(t, app)
+	assert.StringContains(t, body, "snippetbox_snippets_created_total 1")
+	assert.StringContains(t, body, "snippetbox_template_render_errors_total 1")
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
 	"github.com/google/uuid"
 	"github.com/justinas/nosurf"
 )
@@ -23,11 +29,103 @@ func secureHeader(next http.Handler) http.Handler {
 	})
 }
 
+// Define a requestInfo type to hold the details of a request which are
+// included in its log records. It's stored in the request context as a
+// pointer, so that middleware further down the chain (ex: authenticate) can
+// fill in the user ID and matched route pattern for the access log.
+type requestInfo struct {
+	ID      string
+	UserID  uuid.UUID
+	Route   string
+	Started time.Time
+}
+
+// Use a regexp to check X-Request-ID headers, so that clients can't put
+// anything they like in our logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// The requestID middleware gives each request an ID, which is stored in the
+// request context and sent back in the X-Request-ID response header. If a
+// proxy in front of us has already given the request an ID in the
+// X-Request-ID header, it's used instead so that our logs can be matched up
+// with the proxy's.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{ID: id, Started: time.Now()}
+		ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Define a loggingResponseWriter type which records the status code and the
+// number of bytes written, for the access log.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+// The Unwrap() method lets http.ResponseController reach the underlying
+// http.ResponseWriter.
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// The logRequest middleware writes an access log record for each request once
+// it has been handled, including the response status, the number of bytes
+// in the body and how long it took. The request is also counted in the
+// metrics for its route.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		lw := &loggingResponseWriter{ResponseWriter: w}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(lw, r)
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		latency := app.requestLatency(r)
+
+		attrs := append(app.requestAttrs(r),
+			"remote_addr", r.RemoteAddr,
+			"proto", r.Proto,
+			"status", lw.status,
+			"bytes", lw.bytes,
+			"latency", latency,
+		)
+
+		app.logger.Info("request", attrs...)
+
+		var route string
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			route = info.Route
+		}
+		app.metrics.observeRequest(r.Method, route, lw.status, latency)
 	})
 }
 
@@ -43,7 +141,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				w.Header().Set("Connection", "close")
 				// Call the app.serverError helper method to return a 500 Internal Server
 				// Response.
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 
@@ -61,6 +159,7 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 			// Add the path the user is trying to access to the sesion data.
 			app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.Path)
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
 		}
 
 		// Else, set the "Cache-Control: no-store" header so that pages required
@@ -73,6 +172,135 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// The requireVerifiedEmail middleware is used after requireAuthentication on
+// routes which need the user to have verified their email address (ex:
+// publishing a snippet). Users who haven't are redirected to their account
+// page, where they can ask for a new verification link.
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified, err := app.emailVerified(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !verified {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address first. You can ask for a new verification link below.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAdmin middleware is used after requireAuthentication on routes
+// which only administrators can use. Other users get a 403 Forbidden
+// response.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.users.Get(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !user.IsAdmin {
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAPIVerifiedEmail middleware is the API equivalent of
+// requireVerifiedEmail, which sends a JSON 403 Forbidden response instead of
+// redirecting.
+func (app *application) requireAPIVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified, err := app.emailVerified(r)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		if !verified {
+			app.apiError(w, r, http.StatusForbidden, "you must verify your email address to access this resource")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAPIAuthentication middleware is the API equivalent of
+// requireAuthentication. Instead of redirecting to the login page it sends a
+// JSON 401 Unauthorized response.
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiError(w, r, http.StatusUnauthorized, "you must be authenticated to access this resource")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireScope() func returns a middleware which sends a 403 Forbidden
+// response if the request was authenticated with an API token that doesn't
+// grant the given scope.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(tokenContextKey).(*models.Token)
+			if ok && !token.Allows(scope) {
+				app.apiError(w, r, http.StatusForbidden, fmt.Sprintf("this token does not have the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The requireJSON middleware sends a 415 Unsupported Media Type response for
+// API requests with a body that isn't declared as JSON. It also sets the
+// "Cache-Control: no-store" header, since API responses shouldn't be cached.
+func (app *application) requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				app.apiError(w, r, http.StatusUnsupportedMediaType, "the Content-Type header must be application/json")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The contextWithUser() func returns a copy of the request with the given
+// user marked as authenticated in the request context.
+func contextWithUser(r *http.Request, userID uuid.UUID) *http.Request {
+	ctx := context.WithValue(r.Context(), isAuthenticatedCOntextKey, true)
+	ctx = context.WithValue(ctx, authenticatedUserIDContextKey, userID)
+
+	// Record the user for the access log, which is written by middleware
+	// that runs before the user is known.
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.UserID = userID
+	}
+
+	return r.WithContext(ctx)
+}
+
 // Create a NoSurf middleware func which uses a customized CSRF coockie with the
 // Secure, Path and HttpOnly attributes set.
 func noSurf(next http.Handler) http.Handler {
@@ -101,7 +329,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Else, check if a user with that id exists in our database.
 		exists, err := app.users.Exists(uuid.MustParse(id))
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 
@@ -109,12 +337,78 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// authenticated user who exists in our database. We create a new copy of
 		// the request (with an isAuthenticatedContextKey value of true in the request
 		// context) and assign it to r.
+		// We also store the user's ID in the context, so that handlers don't
+		// need to know how the user was authenticated.
 		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedCOntextKey, true)
-			r = r.WithContext(ctx)
+			r = contextWithUser(r, uuid.MustParse(id))
 		}
 
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Define how often the last seen time of a session is updated. Updating it
+// on every request would mean writing the session to the store every time.
+const sessionActivityInterval = time.Minute
+
+// The trackSession middleware records when an authenticated session was last
+// used, and the IP address it was used from, so that they can be shown on
+// the active sessions page.
+func (app *application) trackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isAuthenticated(r) {
+			now := app.clock()
+
+			lastSeenOn := app.sessionTime(r.Context(), "lastSeenOn")
+			if now.Sub(lastSeenOn) >= sessionActivityInterval {
+				app.sessionManager.Put(r.Context(), "lastSeenOn", now.Unix())
+				app.sessionManager.Put(r.Context(), "ipAddress", remoteIP(r))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The authenticateToken middleware is the API equivalent of authenticate. It
+// checks for an "Authorization: Bearer <token>" header and, if the token is
+// valid, adds the token's user (and the token itself) to the request
+// context. Requests without an Authorization header are passed on as
+// anonymous requests, but an invalid token is always rejected.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Authorization header, so make sure that
+		// caches don't share it between clients.
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, plaintext, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || plaintext == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiError(w, r, http.StatusUnauthorized, "invalid or missing authentication token")
+			return
+		}
+
+		token, err := app.tokens.Authenticate(plaintext)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				app.apiError(w, r, http.StatusUnauthorized, "invalid or missing authentication token")
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		r = contextWithUser(r, token.UserID)
+		r = r.WithContext(context.WithValue(r.Context(), tokenContextKey, token))
+
+		next.ServeHTTP(w, r)
+	})
+}
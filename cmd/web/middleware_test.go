@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/google/uuid"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -72,3 +77,160 @@ func TestSecureHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{
+			name:   "Generated",
+			header: "",
+		},
+		{
+			name:     "Propagated",
+			header:   "a1b2c3d4-from-the-proxy",
+			wantSame: true,
+		},
+		{
+			name:   "Invalid",
+			header: "bad id\nwith a newline",
+		},
+		{
+			name:   "Too long",
+			header: strings.Repeat("a", 129),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+
+			// Check that the ID given to the next handler is the one in the
+			// response header.
+			var contextID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextID = r.Context().Value(requestInfoContextKey).(*requestInfo).ID
+			})
+
+			app.requestID(next).ServeHTTP(rr, r)
+
+			id := rr.Result().Header.Get("X-Request-ID")
+			assert.Equal(t, id, contextID)
+
+			if tt.wantSame {
+				assert.Equal(t, id, tt.header)
+			} else {
+				_, err := uuid.Parse(id)
+				assert.NilError(t, err)
+			}
+		})
+	}
+}
+
+// The logRecords helper decodes the JSON log records written to buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func TestLogRequest(t *testing.T) {
+	app := newTestApplication(t)
+
+	buf := new(bytes.Buffer)
+	app.logger = slog.New(slog.NewJSONHandler(buf, nil))
+
+	userID := uuid.MustParse("9c2e1b9e-3a5d-4d0a-9b0e-0f6a4c8f2d11")
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus float64
+		wantBytes  float64
+		wantLevel  []string
+	}{
+		{
+			name: "Success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				contextWithUser(r, userID)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("Created"))
+			},
+			wantStatus: http.StatusCreated,
+			wantBytes:  7,
+			wantLevel:  []string{"INFO"},
+		},
+		{
+			name: "Server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				app.serverError(w, contextWithUser(r, userID), errors.New("database is down"))
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBytes:  22,
+			wantLevel:  []string{"ERROR", "INFO"},
+		},
+		{
+			name: "Panic",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				contextWithUser(r, userID)
+				panic("oops")
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBytes:  22,
+			wantLevel:  []string{"ERROR", "INFO"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			r, err := http.NewRequest(http.MethodGet, "/snippet/view/1?x=y", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("X-Request-ID", "req-123")
+
+			chain := app.requestID(app.logRequest(app.recoverPanic(tt.handler)))
+			chain.ServeHTTP(httptest.NewRecorder(), r)
+
+			records := logRecords(t, buf)
+			assert.Equal(t, len(records), len(tt.wantLevel))
+
+			// Check that every record has the request and user IDs, the
+			// status, the number of bytes and the latency.
+			for i, rec := range records {
+				assert.Equal(t, rec["level"], any(tt.wantLevel[i]))
+				assert.Equal(t, rec["request_id"], any("req-123"))
+				assert.Equal(t, rec["user_id"], any(userID.String()))
+				assert.Equal(t, rec["uri"], any("/snippet/view/1?x=y"))
+				assert.Equal(t, rec["status"], any(tt.wantStatus))
+				assert.Equal(t, rec["bytes"], any(tt.wantBytes))
+
+				_, ok := rec["latency"].(float64)
+				assert.Equal(t, ok, true)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/migrations"
+)
+
+// Define the usage message for the migrate subcommand.
+const migrateUsage = "usage: web [flags] migrate up | down [N|all] | status"
+
+// The runMigrate() func runs the migrate subcommand with the given args:
+// "up" applies every pending migration, "down" reverts the newest N
+// migrations (1 by default, or all of them), and "status" writes a table of
+// the migrations to w.
+func runMigrate(ctx context.Context, m *migrations.Migrator, logger *slog.Logger, w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+
+		done, err := m.Up(ctx)
+		for _, mg := range done {
+			logger.Info("applied migration", "version", mg.Version, "name", mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			logger.Info("database is up to date", "version", m.Latest())
+		}
+
+	case "down":
+		steps := 1
+
+		switch {
+		case len(args) == 1:
+		case len(args) == 2 && args[1] == "all":
+			steps = len(m.Migrations)
+		case len(args) == 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New(migrateUsage)
+			}
+			steps = n
+		default:
+			return errors.New(migrateUsage)
+		}
+
+		done, err := m.Down(ctx, steps)
+		for _, mg := range done {
+			logger.Info("reverted migration", "version", mg.Version, "name", mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			logger.Info("no migrations to revert")
+		}
+
+	case "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied() {
+				applied = s.AppliedOn.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
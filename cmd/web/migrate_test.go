@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/migrations"
+)
+
+func TestRunMigrateUsage(t *testing.T) {
+	// Use a migrator without a database, since none of these args should get
+	// as far as using it.
+	m, err := migrations.New(nil)
+	assert.NilError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"No command", []string{}},
+		{"Unknown command", []string{"sideways"}},
+		{"Extra args to up", []string{"up", "2"}},
+		{"Invalid steps", []string{"down", "two"}},
+		{"Zero steps", []string{"down", "0"}},
+		{"Extra args to status", []string{"status", "now"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runMigrate(context.Background(), m, logger, new(bytes.Buffer), tt.args)
+			assert.Equal(t, err.Error(), migrateUsage)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
 	"github.com/Avixph/learn-go-snippetbox/ui"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
@@ -10,8 +11,9 @@ import (
 
 // The routes() method returns a http.Handler containing our application routes.
 func (app *application) routes() http.Handler {
-	// Initialize a new httprouter router
-	router := httprouter.New()
+	// Initialize a new httprouter router, wrapped so that the pattern of the
+	// route which matches each request is recorded for the metrics.
+	router := &patternRouter{httprouter.New()}
 
 	// Create a handler func that wraps our notFound() helper, and then assign it
 	// as the custom handler for 404 Not Found responses. We can also set a
@@ -37,10 +39,34 @@ func (app *application) routes() http.Handler {
 	// Add a GET /ping route.
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
 
+	// Add the liveness and readiness routes for load balancers and
+	// orchestrators.
+	router.HandlerFunc(http.MethodGet, "/healthz", app.healthz)
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readyz)
+
+	// Add the raw and download routes. These are meant for tools like curl,
+	// so they don't use sessions or CSRF protection.
+	router.HandlerFunc(http.MethodGet, "/snippet/raw/:id", app.snippetRaw)
+	router.HandlerFunc(http.MethodGet, "/snippet/download/:id", app.snippetDownload)
+
+	// Create a separate middleware chain for the JSON API. The API is used by
+	// scripts rather than browsers, so it doesn't use sessions or CSRF
+	// protection. Instead users authenticate with a personal API token, and
+	// routes which change data require a token with the write scope.
+	api := alice.New(app.requireJSON, app.authenticateToken)
+	apiProtected := api.Append(app.requireAPIAuthentication, app.requireScope(models.ScopeWrite))
+
+	router.Handler(http.MethodGet, "/api/v1/snippets", api.ThenFunc(app.apiSnippetList))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id", api.ThenFunc(app.apiSnippetGet))
+	router.Handler(http.MethodPost, "/api/v1/snippets", apiProtected.Append(app.requireAPIVerifiedEmail).ThenFunc(app.apiSnippetCreate))
+	router.Handler(http.MethodPut, "/api/v1/snippets/:id", apiProtected.ThenFunc(app.apiSnippetUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id", apiProtected.ThenFunc(app.apiSnippetDelete))
+
 	// Create a middleware chain containing the middleware specific to our
 	// unprotected application routes using the "dynamic" middleware chain.
-	// Use the noSurf and authenticate middleware on all our 'dynamic' routes.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	// Use the noSurf and authenticate middleware on all our 'dynamic' routes,
+	// and keep track of when each authenticated session was last used.
+	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate, app.trackSession)
 
 	// Register the home, snippetView and snippetCreate funcs as handlers for the
 	// corrisponding URL patrerns with the serverrouter. Swap the route
@@ -52,27 +78,74 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	// Add the About route.
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
+	router.Handler(http.MethodGet, "/search", dynamic.ThenFunc(app.search))
+	router.Handler(http.MethodGet, "/tag/:name", dynamic.ThenFunc(app.tagView))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/view/:id/history", dynamic.ThenFunc(app.snippetHistory))
+	router.Handler(http.MethodGet, "/snippet/view/:id/diff", dynamic.ThenFunc(app.snippetDiff))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignupForm))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLoginForm))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLogin))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorForm))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodGet, "/user/login/oidc/:provider", dynamic.ThenFunc(app.oidcLogin))
+	router.Handler(http.MethodGet, "/user/login/oidc/:provider/callback", dynamic.ThenFunc(app.oidcCallback))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgotForm))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgot))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", dynamic.ThenFunc(app.userPasswordResetForm))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", dynamic.ThenFunc(app.userPasswordReset))
+	router.Handler(http.MethodGet, "/user/verify/:token", dynamic.ThenFunc(app.userVerifyEmail))
+	router.Handler(http.MethodGet, "/user/email/confirm/:token", dynamic.ThenFunc(app.userEmailConfirm))
 
 	// Create a protected (authenticated) middleware chain containing the
 	// middleware specific to our "protected" middleware chain which includes the
 	// requireAuthentication middleware.
 	protected := dynamic.Append(app.requireAuthentication)
 
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreateForm))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreate))
+	// Create a chain for the routes which also need the user to have verified
+	// their email address, such as publishing a snippet.
+	verified := protected.Append(app.requireVerifiedEmail)
+
+	router.Handler(http.MethodGet, "/snippet/create", verified.ThenFunc(app.snippetCreateForm))
+	router.Handler(http.MethodPost, "/snippet/create", verified.ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", protected.ThenFunc(app.snippetEditForm))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", protected.ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", protected.ThenFunc(app.snippetDelete))
 	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodGet, "/account/update", protected.ThenFunc(app.accountUpdateForm))
+	router.Handler(http.MethodPost, "/account/update", protected.ThenFunc(app.accountUpdate))
+	router.Handler(http.MethodGet, "/account/email/update", protected.ThenFunc(app.accountEmailUpdateForm))
+	router.Handler(http.MethodPost, "/account/email/update", protected.ThenFunc(app.accountEmailUpdate))
+	router.Handler(http.MethodGet, "/account/activity", protected.ThenFunc(app.accountActivity))
+	router.Handler(http.MethodPost, "/account/verify/resend", protected.ThenFunc(app.accountVerifyResend))
+	router.Handler(http.MethodGet, "/account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnableForm))
+	router.Handler(http.MethodPost, "/account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnable))
+	router.Handler(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisable))
+	router.Handler(http.MethodGet, "/account/sessions", protected.ThenFunc(app.accountSessions))
+	router.Handler(http.MethodPost, "/account/sessions/revoke/:id", protected.ThenFunc(app.accountSessionRevoke))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-others", protected.ThenFunc(app.accountSessionRevokeOthers))
+	router.Handler(http.MethodPost, "/account/token/create", protected.ThenFunc(app.tokenCreate))
+	router.Handler(http.MethodPost, "/account/token/revoke/:id", protected.ThenFunc(app.tokenRevoke))
 	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.userPasswordUpdateForm))
 	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.userPasswordUpdate))
+	router.Handler(http.MethodGet, "/account/export", protected.ThenFunc(app.accountExport))
+	router.Handler(http.MethodGet, "/account/delete", protected.ThenFunc(app.accountDeleteForm))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.accountDelete))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogout))
 
-	// Create a middleware chain containing our 'standard' middleware (app.recoverPanic,
-	// app.logRequest, secureHeader) which will be used for every request received.
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeader)
+	// Create a chain for the routes which only administrators can use.
+	admin := protected.Append(app.requireAdmin)
+
+	router.Handler(http.MethodGet, "/admin/lockouts", admin.ThenFunc(app.adminLockouts))
+	router.Handler(http.MethodPost, "/admin/lockouts/unlock", admin.ThenFunc(app.adminLockoutUnlock))
+
+	// Create a middleware chain containing our 'standard' middleware (app.requestID,
+	// app.logRequest, app.recoverPanic, secureHeader) which will be used for every
+	// request received. The request ID comes first so that every log record
+	// has it, and app.logRequest wraps app.recoverPanic so that requests
+	// which panic are logged with their 500 status.
+	standard := alice.New(app.requestID, app.logRequest, app.recoverPanic, secureHeader)
 
 	// Return the 'standard' middleware chain followed by the httprouter
 	return standard.Then(router)
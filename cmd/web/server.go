@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Define a sessionStore interface for the session store's background
+// cleanup, which has to be stopped before the database is closed.
+type sessionStore interface {
+	StopCleanup()
+}
+
+// Define a shutdownConfig type to hold the settings for a graceful shutdown,
+// and the dependencies which are stopped after the servers. Delay is how
+// long the servers keep handling requests after /readyz starts reporting
+// that they aren't ready, which gives load balancers time to notice.
+type shutdownConfig struct {
+	delay   time.Duration
+	timeout time.Duration
+	store   sessionStore
+	db      io.Closer
+}
+
+// The serve() method starts the server over TLS and the admin server over
+// plain HTTP, and blocks until one of them fails to start or the process
+// receives a SIGINT or SIGTERM signal. On a signal the servers are shut down
+// gracefully (see shutdown()).
+func (app *application) serve(srv, adminSrv *http.Server, certFile, keyFile string, sc shutdownConfig) error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	serveErr := make(chan error, 2)
+
+	go func() {
+		app.logger.Info("starting server", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServeTLS(certFile, keyFile)
+	}()
+
+	go func() {
+		app.logger.Info("starting admin server", "addr", adminSrv.Addr)
+		serveErr <- adminSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case s := <-quit:
+		app.logger.Info("shutting down server", "signal", s.String())
+	}
+
+	// Stop catching signals, so that a second Ctrl+C kills the process
+	// straight away if the shutdown gets stuck.
+	signal.Stop(quit)
+
+	return app.shutdown([]*http.Server{srv, adminSrv}, sc)
+}
+
+// The shutdown() method stops the application in order: it marks the
+// instance as not ready and waits for the delay, stops the servers accepting
+// connections and waits for in-flight requests, waits for background
+// goroutines (ex: sending emails), stops the session store's cleanup, and
+// finally closes the database connection pool. Each phase is logged. The
+// servers and background goroutines share the timeout, but the later phases
+// always run so that the database is closed cleanly.
+func (app *application) shutdown(servers []*http.Server, sc shutdownConfig) error {
+	app.logger.Info("marking instance as not ready", "delay", sc.delay)
+	app.shuttingDown.Store(true)
+	time.Sleep(sc.delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sc.timeout)
+	defer cancel()
+
+	var errs []error
+
+	for _, srv := range servers {
+		app.logger.Info("draining connections", "addr", srv.Addr, "timeout", sc.timeout)
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("shutting down server %s: %w", srv.Addr, err))
+			srv.Close()
+		}
+	}
+
+	app.logger.Info("waiting for background tasks")
+	err := app.waitBackground(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("waiting for background tasks: %w", err))
+	}
+
+	// Sessions are committed to the store before each response is written,
+	// so once the requests have drained only the cleanup goroutine is left.
+	if sc.store != nil {
+		app.logger.Info("stopping session store")
+		sc.store.StopCleanup()
+	}
+
+	app.logger.Info("closing database connection pool")
+	err = sc.db.Close()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("closing database: %w", err))
+	}
+
+	if len(errs) == 0 {
+		app.logger.Info("stopped server")
+	}
+
+	return errors.Join(errs...)
+}
+
+// The waitBackground() method waits for the goroutines tracked by app.wg to
+// finish, or for ctx to be done, whichever comes first.
+func (app *application) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
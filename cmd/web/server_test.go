@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+// Define a shutdownRecorder type which stands in for the session store and
+// database, and records the order in which the shutdown phases happen.
+type shutdownRecorder struct {
+	mu     sync.Mutex
+	phases []string
+}
+
+func (r *shutdownRecorder) record(phase string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.phases = append(r.phases, phase)
+}
+
+func (r *shutdownRecorder) StopCleanup() { r.record("session store") }
+func (r *shutdownRecorder) Close() error { r.record("database"); return nil }
+
+func TestShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	rec := &shutdownRecorder{}
+
+	// Use a handler which doesn't respond until it's released, and starts a
+	// background task which takes a little while.
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			app.background(func() {
+				time.Sleep(50 * time.Millisecond)
+				rec.record("background task")
+			})
+			w.Write([]byte("OK"))
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	go srv.Serve(ln)
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+
+	go func() {
+		rs, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer rs.Body.Close()
+		body, err := io.ReadAll(rs.Body)
+		response <- result{body: string(body), err: err}
+	}()
+
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- app.shutdown([]*http.Server{srv}, shutdownConfig{timeout: 5 * time.Second, store: rec, db: rec})
+	}()
+
+	// Give the shutdown a moment to start before the request finishes, and
+	// check that the in-flight request still gets its response.
+	time.Sleep(20 * time.Millisecond)
+	rec.record("request")
+	close(release)
+
+	res := <-response
+	assert.NilError(t, res.err)
+	assert.Equal(t, res.body, "OK")
+
+	assert.NilError(t, <-shutdownErr)
+	assert.Equal(t, app.shuttingDown.Load(), true)
+
+	want := []string{"request", "background task", "session store", "database"}
+	assert.Equal(t, len(rec.phases), len(want))
+	for i := range want {
+		assert.Equal(t, rec.phases[i], want[i])
+	}
+
+	// Check that the server no longer accepts connections.
+	_, err = http.Get("http://" + ln.Addr().String())
+	assert.Equal(t, err != nil, true)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	rec := &shutdownRecorder{}
+
+	// Start a background task which doesn't finish until the test does.
+	stuck := make(chan struct{})
+	defer close(stuck)
+	app.background(func() { <-stuck })
+
+	srv := &http.Server{}
+
+	err := app.shutdown([]*http.Server{srv}, shutdownConfig{timeout: 20 * time.Millisecond, store: rec, db: rec})
+	assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+
+	// Check that the session store and database are still stopped.
+	want := []string{"session store", "database"}
+	assert.Equal(t, len(rec.phases), len(want))
+	for i := range want {
+		assert.Equal(t, rec.phases[i], want[i])
+	}
+}
@@ -3,11 +3,18 @@ package main
 import (
 	"html/template"
 	"io/fs"
+	"net/url"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/diff"
+	"github.com/Avixph/learn-go-snippetbox/internal/highlight"
+	"github.com/Avixph/learn-go-snippetbox/internal/lockout"
 	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc"
 	"github.com/Avixph/learn-go-snippetbox/ui"
+	"github.com/google/uuid"
 )
 
 // Define a templateData type to act as the holding structure for any
@@ -16,14 +23,38 @@ import (
 // Add a Form field with the type "any" a Flash field, a IsAuthenticated field,
 // and a CSRFToken field to the templateData struct.
 type templateData struct {
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	User            *models.User
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string
+	CurrentYear         int
+	Snippet             *models.Snippet
+	Snippets            []*models.Snippet
+	NextPage            string
+	PrevPage            string
+	Tags                []string
+	Highlighted         template.HTML
+	Languages           []highlight.Language
+	Revisions           []*models.Revision
+	FromRevision        *models.Revision
+	ToRevision          *models.Revision
+	Hunks               []diff.Hunk
+	DiffTooLarge        bool
+	SearchResults       []*models.SearchResult
+	User                *models.User
+	Tokens              []*models.Token
+	NewToken            *models.Token
+	TwoFactor           *models.TwoFactor
+	TwoFactorSecret     string
+	TwoFactorURI        template.URL
+	QRCode              template.HTML
+	RecoveryCodes       []string
+	Sessions            []activeSession
+	Lockouts            []lockout.Record
+	SnippetPolicy       models.SnippetPolicy
+	AuditEvents         []*models.AuditEvent
+	LoginProviders      []*oidc.Provider
+	Form                any
+	Flash               string
+	IsAuthenticated     bool
+	AuthenticatedUserID uuid.UUID
+	CSRFToken           string
 }
 
 // Create a humanDate func that returns a nicely formatted string
@@ -38,11 +69,50 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// Create a highlightMatches func which turns a search excerpt into HTML. The excerpt
+// is escaped first, and only then are the highlight markers replaced with
+// <mark> tags, so that any HTML in the snippet content is never rendered.
+// Markers which don't pair up are dropped (and an unclosed <mark> is
+// closed), so that the tags are always balanced.
+func highlightMatches(excerpt string) template.HTML {
+	escaped := template.HTMLEscapeString(excerpt)
+
+	var b strings.Builder
+	open := false
+
+	for _, r := range escaped {
+		switch string(r) {
+		case models.HighlightStart:
+			if !open {
+				b.WriteString("<mark>")
+				open = true
+			}
+		case models.HighlightStop:
+			if open {
+				b.WriteString("</mark>")
+				open = false
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	if open {
+		b.WriteString("</mark>")
+	}
+
+	return template.HTML(b.String())
+}
+
 // Initialize a template.FuncMap object and store it in a global variable.
 // This is essentially a string-keyed map that acts as a lookup between the
 // names of our custom template funcs and the funcs themselves.
 var templFunctions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":        humanDate,
+	"highlightMatches": highlightMatches,
+	"join":             strings.Join,
+	"language":         highlight.Label,
+	"pathEscape":       url.PathEscape,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
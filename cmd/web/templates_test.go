@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"html/template"
 	"testing"
 	"time"
 
 	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
 )
 
 func TestHuanDate(t *testing.T) {
@@ -46,3 +49,51 @@ func TestHuanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestHighlightMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		excerpt string
+		want    template.HTML
+	}{
+		{
+			name:    "Marked words",
+			excerpt: "An old " + models.HighlightStart + "silent" + models.HighlightStop + " pond",
+			want:    "An old <mark>silent</mark> pond",
+		},
+		{
+			name:    "Escapes content",
+			excerpt: "<script>" + models.HighlightStart + "alert" + models.HighlightStop + "</script>",
+			want:    "&lt;script&gt;<mark>alert</mark>&lt;/script&gt;",
+		},
+		{
+			name:    "Stray markers",
+			excerpt: models.HighlightStop + "An old " + models.HighlightStart + "silent" + models.HighlightStart + " pond",
+			want:    "An old <mark>silent pond</mark>",
+		},
+		{
+			name:    "Empty",
+			excerpt: "",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, highlightMatches(tt.excerpt), tt.want)
+		})
+	}
+}
+
+func TestTagsTemplate(t *testing.T) {
+	cache, err := newTemplateCache()
+	assert.NilError(t, err)
+
+	var buf bytes.Buffer
+	err = cache["view.html"].ExecuteTemplate(&buf, "tags", []string{"c#", "c++"})
+	assert.NilError(t, err)
+
+	// Check that the "#" is escaped, rather than starting a fragment.
+	assert.StringContains(t, buf.String(), `<a class="tag" href="/tag/c%23">c#</a>`)
+	assert.StringContains(t, buf.String(), `<a class="tag" href="/tag/c&#43;&#43;">c&#43;&#43;</a>`)
+}
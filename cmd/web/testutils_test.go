@@ -4,16 +4,23 @@ import (
 	"bytes"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/highlight"
+	"github.com/Avixph/learn-go-snippetbox/internal/lockout"
+	"github.com/Avixph/learn-go-snippetbox/internal/mailer"
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
 	"github.com/Avixph/learn-go-snippetbox/internal/models/mocks"
+	"github.com/Avixph/learn-go-snippetbox/internal/totp"
+	"github.com/Avixph/learn-go-snippetbox/internal/validator"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 )
@@ -35,6 +42,13 @@ func extractCSRFToken(t *testing.T, body string) string {
 	return html.UnescapeString(string(matches[1]))
 }
 
+// Define the fixed time returned by the test application's clock.
+var testTime = time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+// Define a password which is in the test application's breached passwords
+// corpus.
+const breachedPassword = "correct horse battery staple"
+
 // Create a newTestApplication helper which returns an instance of our
 // application struct  containing mocked dependencies.
 func newTestApplication(t *testing.T) *application {
@@ -55,14 +69,37 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	lockouts := lockout.NewMemory()
+
+	// Use a breached passwords corpus containing only the SHA-1 hash of
+	// breachedPassword.
+	breached := validator.NewBreachedPasswords(fstest.MapFS{
+		"ABF7A.txt": {Data: []byte("AD6438836DBE526AA231ABDE2D0EEF74D42:12\n")},
+	})
+
 	return &application{
-		errorLog:       log.New(io.Discard, "", 0),
-		infoLog:        log.New(io.Discard, "", 0),
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
 		snippets:       &mocks.SnippetModel{},
 		users:          &mocks.UserModel{},
+		tokens:         &mocks.TokenModel{},
+		passwordResets: &mocks.PasswordResetModel{},
+		verifications:  &mocks.EmailVerificationModel{},
+		twoFactor:      &mocks.TwoFactorModel{},
+		auditLog:       &mocks.AuditModel{},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		highlights:     highlight.NewCache(1 << 20),
+		mailer:         &mailer.Memory{},
+		baseURL:        "https://snippetbox.test",
+		clock:          func() time.Time { return testTime },
+		lockouts:       lockouts,
+		accountLimiter: lockout.New(lockouts, "account", accountLoginPolicy),
+		ipLimiter:      lockout.New(lockouts, "ip", ipLoginPolicy),
+		passwordPolicy: &validator.PasswordPolicy{MinChars: 16, Breached: breached},
+		snippetPolicy:  models.DeleteSnippets,
+		metrics:        newMetrics(nil),
+		health:         &mocks.HealthModel{},
 	}
 }
 
@@ -139,3 +176,34 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status, headers and body.
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// Implement a login method which logs the test server client in as the user
+// with the given credentials, so that subsequent requests made with the
+// client are authenticated.
+func (ts *testServer) login(t *testing.T, email, password string) {
+	// Make a GET /user/login request and extract the CSRF token from the
+	// response.
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
+
+// The totpCode helper returns the TOTP code for the given secret at the
+// given time, failing the test if the secret is invalid.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	code, err := totp.Code(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
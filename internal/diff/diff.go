@@ -0,0 +1,249 @@
+// Package diff implements a simple line-based diff, which we use to show how
+// a snippet has changed between two of its revisions.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Define the largest number of lines from each text which Lines() will
+// compare, once the lines that the texts start and end with in common have
+// been skipped. The comparison takes time and memory in proportion to the
+// product of the two line counts, so larger texts are refused.
+const MaxLines = 2000
+
+// ErrTooLarge is returned when texts have too many changed lines to compare.
+var ErrTooLarge = errors.New("diff: texts are too large to compare")
+
+// Define an Op type to describe what happened to a line.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// The String() method returns the name of the operation. This lets us use an
+// Op directly in a template (ex: as part of a CSS class name).
+func (op Op) String() string {
+	switch op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// Define a Line type which holds a single line of a diff and the operation
+// that was applied to it.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// The Prefix() method returns the character used to mark the line in the
+// unified diff format.
+func (l Line) Prefix() string {
+	switch l.Op {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// Define a Hunk type which holds a group of changed lines, along with the
+// surrounding context lines and their position in the old and new text.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// The Header() method returns the hunk's range information in the unified
+// diff format (ex: "@@ -1,3 +1,4 @@").
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// The split() func breaks a text into lines. Carriage returns sent by HTML
+// forms are normalized away, and a single trailing newline does not produce
+// an extra empty line.
+func split(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// The Lines() func returns the full line-by-line edit script which turns
+// text a into text b. It's based on the longest common subsequence of the
+// lines in both texts. If more than MaxLines lines of either text need
+// comparing, the ErrTooLarge error is returned.
+func Lines(a, b string) ([]Line, error) {
+	x, y := split(a), split(b)
+
+	// Skip the lines which both texts start and end with, since they're
+	// unchanged. Usually only a small part of a snippet is edited, so this
+	// keeps the table below small.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	head, tail := x[:prefix], x[len(x)-suffix:]
+	x, y = x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+
+	if len(x) > MaxLines || len(y) > MaxLines {
+		return nil, ErrTooLarge
+	}
+
+	// Build a table where lcs[i][j] holds the length of the longest common
+	// subsequence of x[i:] and y[j:].
+	lcs := make([][]int32, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]Line, 0, len(head)+len(x)+len(y)+len(tail))
+	for _, text := range head {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+
+	// Walk the table from the start of both texts, emitting a line for each
+	// step. Deletions are emitted before insertions so that a changed line
+	// shows up as a "-" line followed by a "+" line.
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{Op: Equal, Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: Delete, Text: x[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, Line{Op: Delete, Text: x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, Line{Op: Insert, Text: y[j]})
+	}
+
+	for _, text := range tail {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+
+	return lines, nil
+}
+
+// The Unified() func returns the changes between text a and text b grouped
+// into hunks, with up to context unchanged lines shown around each change.
+// Changes which are close enough for their context to overlap are merged into
+// a single hunk. If the texts are identical no hunks are returned. Like
+// Lines(), it returns the ErrTooLarge error if the texts are too large to
+// compare.
+func Unified(a, b string, context int) ([]Hunk, error) {
+	lines, err := Lines(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record how many old and new lines come before each position in the
+	// edit script, so that we can work out the line numbers for each hunk.
+	oldBefore := make([]int, len(lines)+1)
+	newBefore := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldBefore[i+1] = oldBefore[i]
+		newBefore[i+1] = newBefore[i]
+		if l.Op != Insert {
+			oldBefore[i+1]++
+		}
+		if l.Op != Delete {
+			newBefore[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+
+	i, prevStop := 0, 0
+	for i < len(lines) {
+		if lines[i].Op == Equal {
+			i++
+			continue
+		}
+
+		// Find the end of the change, absorbing any following changes which
+		// are separated from it by no more than 2*context unchanged lines.
+		end := i
+		for end < len(lines) {
+			if lines[end].Op != Equal {
+				end++
+				continue
+			}
+
+			run := 0
+			for end+run < len(lines) && lines[end+run].Op == Equal {
+				run++
+			}
+			if end+run == len(lines) || run > 2*context {
+				break
+			}
+			end += run
+		}
+
+		start := max(i-context, prevStop)
+		stop := min(end+context, len(lines))
+
+		h := Hunk{
+			OldStart: oldBefore[start],
+			OldLines: oldBefore[stop] - oldBefore[start],
+			NewStart: newBefore[start],
+			NewLines: newBefore[stop] - newBefore[start],
+			Lines:    lines[start:stop],
+		}
+
+		// Line numbers are 1-based, except for an empty range where the start
+		// refers to the line *after* which the change happens.
+		if h.OldLines > 0 {
+			h.OldStart++
+		}
+		if h.NewLines > 0 {
+			h.NewStart++
+		}
+
+		hunks = append(hunks, h)
+		prevStop = stop
+		i = stop
+	}
+
+	return hunks, nil
+}
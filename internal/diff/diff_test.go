@@ -0,0 +1,116 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+// The format() helper renders hunks in the unified diff format, so that the
+// expected results in our tests are easy to read.
+func format(hunks []Hunk) string {
+	var b strings.Builder
+	for _, h := range hunks {
+		b.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			b.WriteString(l.Prefix() + l.Text + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       string
+		b       string
+		context int
+		want    string
+	}{
+		{
+			name:    "Identical",
+			a:       "one\ntwo\nthree",
+			b:       "one\ntwo\nthree",
+			context: 3,
+			want:    "",
+		},
+		{
+			name:    "Changed line",
+			a:       "one\ntwo\nthree",
+			b:       "one\n2\nthree",
+			context: 3,
+			want:    "@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n",
+		},
+		{
+			name:    "Appended line",
+			a:       "one\ntwo",
+			b:       "one\ntwo\nthree\n",
+			context: 1,
+			want:    "@@ -2,1 +2,2 @@\n two\n+three\n",
+		},
+		{
+			name:    "From empty",
+			a:       "",
+			b:       "one\ntwo",
+			context: 3,
+			want:    "@@ -0,0 +1,2 @@\n+one\n+two\n",
+		},
+		{
+			name:    "Carriage returns",
+			a:       "one\r\ntwo",
+			b:       "one\ntwo",
+			context: 3,
+			want:    "",
+		},
+		{
+			name:    "Separate hunks",
+			a:       "a\nb\nc\nd\ne\nf\ng\nh",
+			b:       "A\nb\nc\nd\ne\nf\ng\nH",
+			context: 1,
+			want:    "@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -7,2 +7,2 @@\n g\n-h\n+H\n",
+		},
+		{
+			name:    "Merged hunks",
+			a:       "a\nb\nc\nd",
+			b:       "A\nb\nc\nD",
+			context: 1,
+			want:    "@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n-d\n+D\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, err := Unified(tt.a, tt.b, tt.context)
+			assert.NilError(t, err)
+			assert.Equal(t, format(hunks), tt.want)
+		})
+	}
+}
+
+func TestUnifiedLarge(t *testing.T) {
+	// The numbered() helper returns a text with n lines, each starting with
+	// the given prefix.
+	numbered := func(prefix string, n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "%s %d\n", prefix, i)
+		}
+		return b.String()
+	}
+
+	// Check that texts with too many changed lines are refused, rather than
+	// building a huge table.
+	_, err := Unified(numbered("old", 8000), numbered("new", 8000), 3)
+	assert.Equal(t, err, ErrTooLarge)
+
+	// But a small change to a large text can still be compared, since the
+	// unchanged lines around it are skipped.
+	a := numbered("line", 8000)
+	b := strings.Replace(a, "line 4000\n", "changed 4000\n", 1)
+
+	hunks, err := Unified(a, b, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, format(hunks), "@@ -4000,3 +4000,3 @@\n line 3999\n-line 4000\n+changed 4000\n line 4001\n")
+}
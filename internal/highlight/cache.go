@@ -0,0 +1,88 @@
+package highlight
+
+import (
+	"html/template"
+	"sync"
+)
+
+// Define a Cache type which holds highlighted code, so that a snippet only
+// needs to be tokenized once rather than on every request. It's safe for
+// concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	entries  map[string]template.HTML
+}
+
+// The NewCache() func returns a new Cache which holds at most maxBytes of
+// highlighted code in total.
+func NewCache(maxBytes int) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]template.HTML),
+	}
+}
+
+// The Highlight() method returns the highlighted code stored under key. If
+// there's no entry yet, the code is highlighted and stored. The key should
+// change whenever the code or language changes (ex: by including the time
+// the snippet was last updated).
+func (c *Cache) Highlight(key, name, code string) template.HTML {
+	c.mu.Lock()
+	out, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok {
+		return out
+	}
+
+	// Highlight the code outside of the lock, so that one slow snippet
+	// doesn't hold up requests for other snippets.
+	out = Highlight(name, code)
+
+	// Don't cache code which would take up more than a tenth of the cache,
+	// so that a few huge snippets can't push everything else out.
+	if len(out) > c.maxBytes/10 {
+		return out
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request may have stored the same entry in the meantime.
+	if _, ok := c.entries[key]; ok {
+		return out
+	}
+
+	// If the cache is full, evict arbitrary entries to make room. Go's map
+	// iteration order is randomized, so this behaves like random eviction.
+	for k, v := range c.entries {
+		if c.size+len(out) <= c.maxBytes {
+			break
+		}
+		delete(c.entries, k)
+		c.size -= len(v)
+	}
+	c.entries[key] = out
+	c.size += len(out)
+
+	return out
+}
+
+// The Len() method returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// The Size() method returns the total size of the entries in the cache, in
+// bytes.
+func (c *Cache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
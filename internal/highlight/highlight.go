@@ -0,0 +1,290 @@
+// Package highlight implements a small, pure-Go syntax highlighter. It
+// splits code into tokens (keywords, strings, comments and numbers) and wraps
+// each one in a <span> with a CSS class, so that snippets can be highlighted
+// on the server without any client-side JavaScript.
+package highlight
+
+import (
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Define a Language type which describes the lexical rules our tokenizer
+// needs to know about a programming language.
+type Language struct {
+	Name          string
+	Label         string
+	Extension     string
+	Keywords      []string
+	IgnoreCase    bool
+	LineComments  []string
+	BlockComments [][2]string
+	Quotes        string
+}
+
+// Define the languages we support. The first one, plaintext, is the default
+// and isn't highlighted at all.
+var languages = []Language{
+	{
+		Name:      "plaintext",
+		Label:     "Plain Text",
+		Extension: "txt",
+	},
+	{
+		Name:      "bash",
+		Label:     "Bash",
+		Extension: "sh",
+		Keywords: []string{
+			"case", "do", "done", "elif", "else", "esac", "export", "fi", "for",
+			"function", "if", "in", "local", "return", "select", "then", "until",
+			"while",
+		},
+		LineComments: []string{"#"},
+		Quotes:       `"'`,
+	},
+	{
+		Name:      "go",
+		Label:     "Go",
+		Extension: "go",
+		Keywords: []string{
+			"break", "case", "chan", "const", "continue", "default", "defer",
+			"else", "fallthrough", "for", "func", "go", "goto", "if", "import",
+			"interface", "map", "package", "range", "return", "select", "struct",
+			"switch", "type", "var", "nil", "true", "false",
+		},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        "\"'`",
+	},
+	{
+		Name:      "javascript",
+		Label:     "JavaScript",
+		Extension: "js",
+		Keywords: []string{
+			"async", "await", "break", "case", "catch", "class", "const",
+			"continue", "default", "delete", "do", "else", "export", "extends",
+			"false", "finally", "for", "function", "if", "import", "in",
+			"instanceof", "let", "new", "null", "return", "switch", "this",
+			"throw", "true", "try", "typeof", "undefined", "var", "while", "yield",
+		},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        "\"'`",
+	},
+	{
+		Name:      "python",
+		Label:     "Python",
+		Extension: "py",
+		Keywords: []string{
+			"and", "as", "assert", "async", "await", "break", "class", "continue",
+			"def", "del", "elif", "else", "except", "False", "finally", "for",
+			"from", "global", "if", "import", "in", "is", "lambda", "None",
+			"nonlocal", "not", "or", "pass", "raise", "return", "True", "try",
+			"while", "with", "yield",
+		},
+		LineComments: []string{"#"},
+		Quotes:       `"'`,
+	},
+	{
+		Name:      "sql",
+		Label:     "SQL",
+		Extension: "sql",
+		Keywords: []string{
+			"alter", "and", "as", "asc", "by", "create", "delete", "desc",
+			"distinct", "drop", "exists", "from", "group", "having", "in", "index",
+			"inner", "insert", "into", "is", "join", "left", "limit", "not",
+			"null", "on", "or", "order", "outer", "primary", "key", "references",
+			"returning", "right", "select", "set", "table", "union", "update",
+			"values", "where", "with",
+		},
+		IgnoreCase:    true,
+		LineComments:  []string{"--"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `'"`,
+	},
+	{
+		Name:         "yaml",
+		Label:        "YAML",
+		Extension:    "yaml",
+		Keywords:     []string{"true", "false", "null", "yes", "no"},
+		LineComments: []string{"#"},
+		Quotes:       `"'`,
+	},
+}
+
+// The Languages() func returns the supported languages, in the order they
+// should be offered to users.
+func Languages() []Language {
+	return languages
+}
+
+// The Names() func returns the names of the supported languages.
+func Names() []string {
+	names := make([]string, len(languages))
+	for i, l := range languages {
+		names[i] = l.Name
+	}
+	return names
+}
+
+// The lookup() func returns the language with the given name. If there's no
+// such language, plaintext is returned.
+func lookup(name string) Language {
+	for _, l := range languages {
+		if l.Name == name {
+			return l
+		}
+	}
+	return languages[0]
+}
+
+// The Label() func returns the display label of a language (ex: "Go" for
+// "go").
+func Label(name string) string {
+	return lookup(name).Label
+}
+
+// The Extension() func returns the usual file extension, without the dot,
+// for code written in a language (ex: "py" for "python").
+func Extension(name string) string {
+	return lookup(name).Extension
+}
+
+// The isKeyword() method returns true if word is a keyword of the language.
+func (l Language) isKeyword(word string) bool {
+	for _, k := range l.Keywords {
+		if k == word || (l.IgnoreCase && strings.EqualFold(k, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// The isIdentRune() func returns true if r can appear in an identifier.
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// The Highlight() func tokenizes code using the rules of the named language
+// and returns it as HTML, with each token wrapped in a <span> whose class
+// names the token type (ex: "hl-keyword"). All of the code is HTML escaped,
+// so the result is safe to render as-is.
+func Highlight(name, code string) template.HTML {
+	lang := lookup(name)
+
+	var b strings.Builder
+
+	// Define an emit func which escapes a piece of the code and writes it to
+	// the builder, wrapped in a span if it's a highlighted token.
+	emit := func(class, text string) {
+		if class == "" {
+			b.WriteString(html.EscapeString(text))
+			return
+		}
+		b.WriteString(`<span class="hl-` + class + `">`)
+		b.WriteString(html.EscapeString(text))
+		b.WriteString(`</span>`)
+	}
+
+	if lang.Name == "plaintext" {
+		emit("", code)
+		return template.HTML(b.String())
+	}
+
+	i := 0
+next:
+	for i < len(code) {
+		rest := code[i:]
+
+		for _, bc := range lang.BlockComments {
+			if strings.HasPrefix(rest, bc[0]) {
+				end := strings.Index(rest[len(bc[0]):], bc[1])
+				if end < 0 {
+					end = len(rest)
+				} else {
+					end += len(bc[0]) + len(bc[1])
+				}
+				emit("comment", rest[:end])
+				i += end
+				continue next
+			}
+		}
+
+		for _, lc := range lang.LineComments {
+			if strings.HasPrefix(rest, lc) {
+				end := strings.IndexByte(rest, '\n')
+				if end < 0 {
+					end = len(rest)
+				}
+				emit("comment", rest[:end])
+				i += end
+				continue next
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+
+		switch {
+		case strings.ContainsRune(lang.Quotes, r):
+			// Scan to the closing quote, skipping over escaped characters.
+			// Strings other than backtick strings end at a newline, so that
+			// an unbalanced quote can't swallow the rest of the snippet.
+			end := size
+			for end < len(rest) {
+				c := rest[end]
+				if c == '\\' && r != '`' {
+					end += 2
+					continue
+				}
+				if c == '\n' && r != '`' {
+					break
+				}
+				end++
+				if rune(c) == r {
+					break
+				}
+			}
+			end = min(end, len(rest))
+			emit("string", rest[:end])
+			i += end
+
+		case unicode.IsDigit(r):
+			end := 0
+			for end < len(rest) {
+				c, n := utf8.DecodeRuneInString(rest[end:])
+				if !isIdentRune(c) && c != '.' {
+					break
+				}
+				end += n
+			}
+			emit("number", rest[:end])
+			i += end
+
+		case isIdentRune(r):
+			end := 0
+			for end < len(rest) {
+				c, n := utf8.DecodeRuneInString(rest[end:])
+				if !isIdentRune(c) {
+					break
+				}
+				end += n
+			}
+			word := rest[:end]
+			if lang.isKeyword(word) {
+				emit("keyword", word)
+			} else {
+				emit("", word)
+			}
+			i += end
+
+		default:
+			emit("", rest[:size])
+			i += size
+		}
+	}
+
+	return template.HTML(b.String())
+}
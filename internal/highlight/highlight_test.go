@@ -0,0 +1,105 @@
+package highlight
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		code     string
+		want     template.HTML
+	}{
+		{
+			name:     "Plain text is only escaped",
+			language: "plaintext",
+			code:     `if x < 1 { return "a" }`,
+			want:     `if x &lt; 1 { return &#34;a&#34; }`,
+		},
+		{
+			name:     "Unknown language falls back to plain text",
+			language: "cobol",
+			code:     "func",
+			want:     "func",
+		},
+		{
+			name:     "Go keywords, strings and numbers",
+			language: "go",
+			code:     `return "a<b", 42`,
+			want:     `<span class="hl-keyword">return</span> <span class="hl-string">&#34;a&lt;b&#34;</span>, <span class="hl-number">42</span>`,
+		},
+		{
+			name:     "Go comments",
+			language: "go",
+			code:     "x // note\n/* a\nb */y",
+			want:     "x <span class=\"hl-comment\">// note</span>\n<span class=\"hl-comment\">/* a\nb */</span>y",
+		},
+		{
+			name:     "Escaped quotes",
+			language: "go",
+			code:     `"a\"b" c`,
+			want:     `<span class="hl-string">&#34;a\&#34;b&#34;</span> c`,
+		},
+		{
+			name:     "Unterminated string stops at newline",
+			language: "python",
+			code:     "'abc\nif",
+			want:     "<span class=\"hl-string\">&#39;abc</span>\n<span class=\"hl-keyword\">if</span>",
+		},
+		{
+			name:     "SQL keywords ignore case",
+			language: "sql",
+			code:     "SELECT id -- all",
+			want:     `<span class="hl-keyword">SELECT</span> id <span class="hl-comment">-- all</span>`,
+		},
+		{
+			name:     "Identifiers containing keywords",
+			language: "go",
+			code:     "format iffy",
+			want:     "format iffy",
+		},
+		{
+			name:     "Non-ASCII letter after a number",
+			language: "go",
+			code:     "x := 1é",
+			want:     `x := <span class="hl-number">1é</span>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Highlight(tt.language, tt.code), tt.want)
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(1000)
+
+	first := c.Highlight("a", "go", "func")
+	assert.Equal(t, first, `<span class="hl-keyword">func</span>`)
+
+	// A cached entry is returned even if the code passed in has changed,
+	// because the key is the same.
+	assert.Equal(t, c.Highlight("a", "go", "var"), first)
+
+	// Fill the cache with entries of about 80 bytes each.
+	for i := 0; i < 50; i++ {
+		c.Highlight(fmt.Sprint(i), "plaintext", strings.Repeat("x", 80))
+	}
+
+	// The cache never grows beyond its maximum size.
+	assert.Equal(t, c.Size() <= 1000, true)
+	assert.Equal(t, c.Len() > 1, true)
+
+	// Code which would take up too much of the cache isn't stored.
+	before := c.Len()
+	c.Highlight("big", "plaintext", strings.Repeat("x", 200))
+	assert.Equal(t, c.Len(), before)
+}
@@ -0,0 +1,147 @@
+// Package lockout keeps track of failed attempts (such as failed logins) and
+// works out how long a client must wait before trying again. Each failure
+// beyond a threshold doubles the wait, up to a maximum lockout period.
+package lockout
+
+import (
+	"time"
+)
+
+// Define a Record type to hold the failed attempts for a single key.
+type Record struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+	ExpiresOn   time.Time
+}
+
+// Define a Store interface that describes where the records are kept. The
+// records for a key can be dropped once their ExpiresOn time has passed. The
+// Memory store is enough for a single server, but a shared store could be
+// used to share the state between several.
+//
+// Incr() must be atomic, so that concurrent attempts for the same key are
+// all counted and can't all get past the threshold. If the key is locked at
+// now it returns the record unchanged and false. Otherwise it adds one to the
+// failures for the key (starting from zero if the record has expired), locks
+// the key for policy.Delay() of the new number of failures, moves ExpiresOn
+// to at least now plus policy.ResetAfter and the end of the lock, and returns
+// the new record and true. Decr() takes back a failure counted by Incr(),
+// and lifts the lock (any lock from before it was counted had already
+// passed).
+type Store interface {
+	Get(key string, now time.Time) (Record, error)
+	Incr(key string, now time.Time, policy Policy) (Record, bool, error)
+	Decr(key string, now time.Time) error
+	Delete(key string) error
+	List(now time.Time) ([]Record, error)
+}
+
+// Define a Policy type to hold the settings which decide how long a key is
+// locked for. The first Threshold failures are free, after which the key is
+// locked for BaseDelay, doubling with every further failure up to MaxDelay.
+// The failures are forgotten once there have been none for ResetAfter.
+type Policy struct {
+	Threshold  int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	ResetAfter time.Duration
+}
+
+// The Delay() method returns how long a key should be locked for after the
+// given number of failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := p.Threshold; i < failures; i++ {
+		d *= 2
+		if d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	return min(d, p.MaxDelay)
+}
+
+// Define a Limiter type which applies a Policy to the records for one kind
+// of key (ex: email addresses or IP addresses). The prefix is added to every
+// key, so that several limiters can share a store.
+type Limiter struct {
+	store  Store
+	prefix string
+	policy Policy
+}
+
+// The New() func returns a new Limiter which keeps its records in the given
+// store, under keys starting with "<prefix>:".
+func New(store Store, prefix string, policy Policy) *Limiter {
+	return &Limiter{
+		store:  store,
+		prefix: prefix + ":",
+		policy: policy,
+	}
+}
+
+// The Check() method returns how much longer the key is locked for, or zero
+// if it isn't locked.
+func (l *Limiter) Check(key string, now time.Time) (time.Duration, error) {
+	rec, err := l.store.Get(l.prefix+key, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if rec.LockedUntil.After(now) {
+		return rec.LockedUntil.Sub(now), nil
+	}
+
+	return 0, nil
+}
+
+// The Attempt() method counts an attempt for the key before it's checked,
+// and returns how much longer the key is locked for if the attempt is
+// refused, or zero if it can go ahead. The attempt counts as a failure (and
+// locks the key if it reaches the threshold) until Reset() is called after
+// it succeeds. Counting attempts up front, rather than after the slow check,
+// means that attempts made at the same time can't all get in before the
+// failures are recorded.
+func (l *Limiter) Attempt(key string, now time.Time) (time.Duration, error) {
+	rec, counted, err := l.store.Incr(l.prefix+key, now, l.policy)
+	if err != nil {
+		return 0, err
+	}
+
+	if !counted {
+		return rec.LockedUntil.Sub(now), nil
+	}
+
+	return 0, nil
+}
+
+// The Cancel() method takes back an attempt counted by Attempt() which
+// succeeded, when the success isn't enough to Reset() the key (ex: a correct
+// password from a user who still has to enter an authentication code).
+func (l *Limiter) Cancel(key string, now time.Time) error {
+	return l.store.Decr(l.prefix+key, now)
+}
+
+// The Fail() method records a failed attempt for a key whose attempts aren't
+// counted up front (ex: IP addresses, which shouldn't be locked by
+// successful logins), and returns how long the key is now locked for (which
+// is zero if it's still below the threshold).
+func (l *Limiter) Fail(key string, now time.Time) (time.Duration, error) {
+	rec, _, err := l.store.Incr(l.prefix+key, now, l.policy)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(rec.LockedUntil.Sub(now), 0), nil
+}
+
+// The Reset() method forgets the failed attempts for the key. It's used
+// after a successful attempt.
+func (l *Limiter) Reset(key string) error {
+	return l.store.Delete(l.prefix + key)
+}
@@ -0,0 +1,202 @@
+package lockout
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+var testPolicy = Policy{
+	Threshold:  3,
+	BaseDelay:  time.Minute,
+	MaxDelay:   10 * time.Minute,
+	ResetAfter: time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.failures), func(t *testing.T) {
+			assert.Equal(t, testPolicy.Delay(tt.failures), tt.want)
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	store := NewMemory()
+	accounts := New(store, "account", testPolicy)
+	ips := New(store, "ip", testPolicy)
+
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	// The first failures shouldn't lock the key.
+	for i := 0; i < 2; i++ {
+		d, err := accounts.Fail("falso@example.com", now)
+		assert.NilError(t, err)
+		assert.Equal(t, d, time.Duration(0))
+	}
+
+	d, err := accounts.Check("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Duration(0))
+
+	// But the third one should.
+	d, err = accounts.Fail("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Minute)
+
+	d, err = accounts.Check("falso@example.com", now.Add(20*time.Second))
+	assert.NilError(t, err)
+	assert.Equal(t, d, 40*time.Second)
+
+	// The lock should only apply to the same key with the same prefix.
+	d, err = ips.Check("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Duration(0))
+
+	// Once the lock has passed the key can be tried again, but the next
+	// failure doubles the delay.
+	d, err = accounts.Check("falso@example.com", now.Add(time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Duration(0))
+
+	d, err = accounts.Fail("falso@example.com", now.Add(time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, d, 2*time.Minute)
+
+	// The failures are forgotten after a reset...
+	assert.NilError(t, accounts.Reset("falso@example.com"))
+
+	d, err = accounts.Fail("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Duration(0))
+
+	// ...or once there haven't been any for ResetAfter.
+	later := now.Add(2 * time.Hour)
+
+	rec, err := store.Get("account:falso@example.com", later)
+	assert.NilError(t, err)
+	assert.Equal(t, rec.Failures, 0)
+}
+
+func TestMemoryList(t *testing.T) {
+	store := NewMemory()
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	_, _, err := store.Incr("ip:127.0.0.1", now, testPolicy)
+	assert.NilError(t, err)
+	_, _, err = store.Incr("account:a@example.com", now, testPolicy)
+	assert.NilError(t, err)
+	_, _, err = store.Incr("account:old@example.com", now.Add(-2*time.Hour), testPolicy)
+	assert.NilError(t, err)
+
+	records, err := store.List(now)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, records[0].Key, "account:a@example.com")
+	assert.Equal(t, records[1].Key, "ip:127.0.0.1")
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	store := NewMemory()
+	accounts := New(store, "account", testPolicy)
+
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	// The attempts up to the threshold should go ahead, but the last one
+	// locks the key in case it fails too.
+	for i := 0; i < testPolicy.Threshold; i++ {
+		d, err := accounts.Attempt("falso@example.com", now)
+		assert.NilError(t, err)
+		assert.Equal(t, d, time.Duration(0))
+	}
+
+	d, err := accounts.Check("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Minute)
+
+	// So the next attempt is refused, and isn't counted.
+	d, err = accounts.Attempt("falso@example.com", now.Add(20*time.Second))
+	assert.NilError(t, err)
+	assert.Equal(t, d, 40*time.Second)
+
+	rec, err := store.Get("account:falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, rec.Failures, testPolicy.Threshold)
+
+	// A successful attempt resets the key, lifting the lock.
+	assert.NilError(t, accounts.Reset("falso@example.com"))
+
+	d, err = accounts.Attempt("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Duration(0))
+
+	// Cancelling an attempt takes it back, along with the lock it set.
+	for i := 1; i < testPolicy.Threshold; i++ {
+		_, err = accounts.Attempt("falso@example.com", now)
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, accounts.Cancel("falso@example.com", now))
+
+	d, err = accounts.Check("falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, d, time.Duration(0))
+
+	rec, err = store.Get("account:falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, rec.Failures, testPolicy.Threshold-1)
+}
+
+func TestLimiterConcurrentAttempts(t *testing.T) {
+	store := NewMemory()
+	accounts := New(store, "account", testPolicy)
+
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	// Make attempts for the same key from many goroutines at once, and
+	// check that only the threshold's worth are allowed to go ahead.
+	const n = 50
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := accounts.Attempt("falso@example.com", now)
+			assert.NilError(t, err)
+
+			if d == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, allowed, testPolicy.Threshold)
+
+	rec, err := store.Get("account:falso@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, rec.Failures, testPolicy.Threshold)
+	assert.Equal(t, rec.LockedUntil, now.Add(testPolicy.BaseDelay))
+}
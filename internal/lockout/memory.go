@@ -0,0 +1,124 @@
+package lockout
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Define a Memory type which keeps the records in memory. It's safe for
+// concurrent use. Records are only kept until they expire, so a flood of
+// failures from many different keys doesn't use up memory forever.
+type Memory struct {
+	mu        sync.Mutex
+	records   map[string]Record
+	pruneSize int
+}
+
+// The NewMemory() func returns a new, empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]Record)}
+}
+
+// The Get() method returns the record for the key, or an empty record if
+// there isn't one (or it has expired).
+func (m *Memory) Get(key string, now time.Time) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[key]
+	if !ok || !rec.ExpiresOn.After(now) {
+		return Record{Key: key}, nil
+	}
+
+	return rec, nil
+}
+
+// The Incr() method counts a failure for the key unless it's locked, as
+// described by the Store interface. Every time the number of records doubles
+// the ones which have expired by now are removed, which keeps the cost of
+// pruning constant per Incr() on average.
+func (m *Memory) Incr(key string, now time.Time, policy Policy) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[key]
+	if !ok || !rec.ExpiresOn.After(now) {
+		rec = Record{Key: key}
+	}
+
+	if rec.LockedUntil.After(now) {
+		return rec, false, nil
+	}
+
+	rec.Failures++
+	if delay := policy.Delay(rec.Failures); delay > 0 {
+		rec.LockedUntil = now.Add(delay)
+	}
+	if expiresOn := now.Add(policy.ResetAfter); expiresOn.After(rec.ExpiresOn) {
+		rec.ExpiresOn = expiresOn
+	}
+	if rec.LockedUntil.After(rec.ExpiresOn) {
+		rec.ExpiresOn = rec.LockedUntil
+	}
+	m.records[key] = rec
+
+	if len(m.records) > m.pruneSize {
+		for key, r := range m.records {
+			if !r.ExpiresOn.After(now) {
+				delete(m.records, key)
+			}
+		}
+		m.pruneSize = max(2*len(m.records), 64)
+	}
+
+	return rec, true, nil
+}
+
+// The Decr() method takes back a failure for the key, as described by the
+// Store interface. It does nothing if there's no record for the key.
+func (m *Memory) Decr(key string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[key]
+	if !ok || !rec.ExpiresOn.After(now) || rec.Failures == 0 {
+		return nil
+	}
+
+	rec.Failures--
+	rec.LockedUntil = time.Time{}
+	m.records[key] = rec
+
+	return nil
+}
+
+// The Delete() method removes the record for the key, if there is one.
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+
+	return nil
+}
+
+// The List() method returns the records which haven't expired, ordered by
+// key.
+func (m *Memory) List(now time.Time) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := []Record{}
+	for _, rec := range m.records {
+		if rec.ExpiresOn.After(now) {
+			records = append(records, rec)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+
+	return records, nil
+}
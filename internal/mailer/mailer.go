@@ -0,0 +1,143 @@
+// Package mailer sends the emails used by the application (ex: password
+// reset links). The Mailer interface lets the SMTP implementation used in
+// production be swapped for a File or Memory implementation during local
+// development and testing.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Define a Message type to hold the data for a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Define a Mailer interface which describes the method every mailer has.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// The format() func renders a message in the RFC 5322 format, with the given
+// sender and date. The subject is encoded so that non-ASCII characters are
+// sent safely.
+func format(sender string, msg Message, date time.Time) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+
+	// Normalize the line endings of the body to CRLF, as required by SMTP.
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
+
+// Define an SMTP type which sends messages through an SMTP server. The Addr
+// field holds the server's "host:port" address. If a Username is set, the
+// PLAIN auth mechanism is used (which net/smtp only allows over TLS or to
+// localhost).
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	Sender   string
+}
+
+// The Send() method sends the message through the SMTP server.
+func (m *SMTP) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.Sender, []string{msg.To}, format(m.Sender, msg, time.Now()))
+}
+
+// Define a File type which writes each message to its own ".eml" file in a
+// directory, instead of sending it. It's useful during local development,
+// when there is no SMTP server available.
+type File struct {
+	Dir    string
+	Sender string
+}
+
+// The Send() method writes the message to a new file in the directory, which
+// is created if it doesn't exist.
+func (m *File) Send(msg Message) error {
+	err := os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	f, err := os.CreateTemp(m.Dir, now.Format("20060102-150405-")+"*.eml")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(format(m.Sender, msg, now))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Define a Memory type which keeps the messages it's sent in memory, so that
+// tests can check them. It's safe for concurrent use.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// The Send() method records the message.
+func (m *Memory) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// The Messages() method returns a copy of the messages sent so far.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// The Last() method returns the most recently sent message, and false if no
+// messages have been sent.
+func (m *Memory) Last() (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		return Message{}, false
+	}
+	return m.messages[len(m.messages)-1], true
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{
+		To:      "falso@example.com",
+		Subject: "Reset your password",
+		Body:    "Hello,\nClick the link.",
+	}
+	date := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	got := string(format("Snippetbox <no-reply@example.com>", msg, date))
+
+	assert.StringContains(t, got, "From: Snippetbox <no-reply@example.com>\r\n")
+	assert.StringContains(t, got, "To: falso@example.com\r\n")
+	assert.StringContains(t, got, "Subject: Reset your password\r\n")
+	assert.StringContains(t, got, "Date: Sun, 17 Mar 2024 10:15:00 +0000\r\n")
+	assert.StringContains(t, got, "\r\n\r\nHello,\r\nClick the link.")
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &File{Dir: dir, Sender: "no-reply@example.com"}
+
+	err := m.Send(Message{To: "falso@example.com", Subject: "Hi", Body: "Hello"})
+	assert.NilError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, strings.HasSuffix(entries[0].Name(), ".eml"), true)
+
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	assert.NilError(t, err)
+	assert.StringContains(t, string(b), "To: falso@example.com\r\n")
+}
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+
+	_, ok := m.Last()
+	assert.Equal(t, ok, false)
+
+	m.Send(Message{To: "a@example.com"})
+	m.Send(Message{To: "b@example.com"})
+
+	last, ok := m.Last()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, last.To, "b@example.com")
+	assert.Equal(t, len(m.Messages()), 2)
+}
@@ -0,0 +1,348 @@
+// Package migrations applies the database schema changes which are embedded
+// in the binary. Each change is a pair of files in the sql directory, named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, and changes are
+// applied in version order. The versions which have been applied are
+// recorded in the schema_migrations table, and a PostgreSQL advisory lock
+// stops two processes from migrating the same database at the same time.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Define the key of the advisory lock which is held while migrating. It's
+// an arbitrary number, which only has to differ from any other advisory
+// locks used in the database.
+const lockKey = 7_301_415_926
+
+// Use a regexp to check the names of the migration files, and to pull out
+// the version, name and direction.
+var fileRX = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration holds a schema change, and the SQL to apply and revert it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status holds a migration and when it was applied. AppliedOn is the zero
+// time if the migration is pending.
+type Status struct {
+	Migration
+	AppliedOn time.Time
+}
+
+// The Applied() method reports whether the migration has been applied.
+func (s Status) Applied() bool {
+	return !s.AppliedOn.IsZero()
+}
+
+// The Load() func reads the migrations from the root of fsys, and returns
+// them sorted by version. Every version needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, e := range entries {
+		matches := fileRX.FindStringSubmatch(e.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("migrations: invalid file name %q", e.Name())
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migrations: invalid version in %q", e.Name())
+		}
+
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by %q and %q", version, m.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations in a database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// The New() func returns a Migrator for the migrations which are embedded in
+// the binary.
+func New(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// The Latest() method returns the version of the newest migration, or 0 if
+// there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// The Up() method applies every pending migration in version order, and
+// returns the ones which were applied. Each migration runs in its own
+// transaction, so if one fails the ones before it stay applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.Migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+
+			err = runInTx(ctx, conn, mg.Up, `INSERT INTO schema_migrations (version, name, applied_on)
+				VALUES ($1, $2, (now() at time zone 'utc'))`, mg.Version, mg.Name)
+			if err != nil {
+				return fmt.Errorf("migrations: applying %d_%s: %w", mg.Version, mg.Name, err)
+			}
+
+			done = append(done, mg)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// The Down() method reverts up to steps of the most recently applied
+// migrations, newest first, and returns the ones which were reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("migrations: invalid number of steps %d", steps)
+	}
+
+	known := make(map[int64]Migration)
+	for _, mg := range m.Migrations {
+		known[mg.Version] = mg
+	}
+
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions[:min(steps, len(versions))] {
+			mg, ok := known[v]
+			if !ok {
+				return fmt.Errorf("migrations: version %d is applied but unknown to this binary", v)
+			}
+
+			err = runInTx(ctx, conn, mg.Down, `DELETE FROM schema_migrations WHERE version = $1`, mg.Version)
+			if err != nil {
+				return fmt.Errorf("migrations: reverting %d_%s: %w", mg.Version, mg.Name, err)
+			}
+
+			done = append(done, mg)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// The Status() method returns every migration along with when it was
+// applied. It doesn't take the lock or change the database, so it can be
+// used while another process is migrating.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var exists bool
+
+	err = conn.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the table doesn't exist yet, no migrations have been applied.
+	applied := make(map[int64]time.Time)
+
+	if exists {
+		applied, err = appliedVersions(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, len(m.Migrations))
+	for i, mg := range m.Migrations {
+		statuses[i] = Status{Migration: mg, AppliedOn: applied[mg.Version]}
+	}
+
+	return statuses, nil
+}
+
+// The withLock() method runs fn on a single connection while holding the
+// advisory lock. Advisory locks belong to a session, so the same connection
+// has to be used to take and release the lock. If another process holds
+// the lock, withLock() waits for it to finish.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, unlockErr := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+		err = errors.Join(err, unlockErr)
+	}()
+
+	err = createTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// The createTable() func creates the schema_migrations table if it doesn't
+// exist yet.
+func createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		applied_on TIMESTAMP NOT NULL,
+		PRIMARY KEY (version)
+	)`)
+	return err
+}
+
+// The appliedVersions() func returns the applied versions, and when each
+// was applied.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_on FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+
+	for rows.Next() {
+		var version int64
+		var appliedOn time.Time
+
+		err = rows.Scan(&version, &appliedOn)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedOn
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// The runInTx() func runs a migration's SQL and then the query which
+// records it in schema_migrations, in one transaction. The SQL is run
+// without arguments, so that it can contain several statements.
+func runInTx(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
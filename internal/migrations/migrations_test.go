@@ -0,0 +1,255 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil)
+	assert.NilError(t, err)
+
+	// Check that the versions run from 1 with no gaps, so that a migration
+	// can't be skipped by mistake.
+	for i, mg := range m.Migrations {
+		assert.Equal(t, mg.Version, int64(i+1))
+	}
+	assert.Equal(t, m.Latest(), int64(len(m.Migrations)))
+	assert.Equal(t, m.Migrations[0].Name, "baseline")
+}
+
+func TestLoad(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []int64
+		wantErr string
+	}{
+		{
+			name: "Sorted by version",
+			fsys: fstest.MapFS{
+				"10_ten.up.sql":   file,
+				"10_ten.down.sql": file,
+				"2_two.up.sql":    file,
+				"2_two.down.sql":  file,
+			},
+			want: []int64{2, 10},
+		},
+		{
+			name: "Missing down",
+			fsys: fstest.MapFS{
+				"1_one.up.sql": file,
+			},
+			wantErr: "migrations: version 1 (one) needs both an up and a down file",
+		},
+		{
+			name: "Duplicate version",
+			fsys: fstest.MapFS{
+				"1_one.up.sql":   file,
+				"1_one.down.sql": file,
+				"1_uno.up.sql":   file,
+			},
+			wantErr: `migrations: version 1 is used by "one" and "uno"`,
+		},
+		{
+			name: "Invalid name",
+			fsys: fstest.MapFS{
+				"create_users.sql": file,
+			},
+			wantErr: `migrations: invalid file name "create_users.sql"`,
+		},
+		{
+			name: "Zero version",
+			fsys: fstest.MapFS{
+				"0_zero.up.sql": file,
+			},
+			wantErr: `migrations: invalid version in "0_zero.up.sql"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.fsys)
+
+			if tt.wantErr != "" {
+				assert.Equal(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, len(migrations), len(tt.want))
+			for i, v := range tt.want {
+				assert.Equal(t, migrations[i].Version, v)
+			}
+		})
+	}
+}
+
+// The newTestDB() helper connects to the test database, and reverts any
+// migrations which are left applied when the test finishes.
+func newTestDB(t *testing.T) *sql.DB {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("Unable to load .env directory!")
+	}
+
+	err := godotenv.Load(filepath.Join(filepath.Dir(file), "../..", "/.env"))
+	if err != nil {
+		t.Fatal("No .env file found!")
+	}
+
+	db, err := sql.Open("postgres", os.Getenv("TEST_DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		m, err := New(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = m.Down(context.Background(), len(m.Migrations))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db.Close()
+	})
+
+	return db
+}
+
+func TestMigrator(t *testing.T) {
+	// Skip the test if the "-short" flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("migrations: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	m, err := New(db)
+	assert.NilError(t, err)
+
+	// Check that everything is pending before the first run.
+	statuses, err := m.Status(ctx)
+	assert.NilError(t, err)
+	for _, s := range statuses {
+		assert.Equal(t, s.Applied(), false)
+	}
+
+	// Run the migrations from two goroutines at once. The advisory lock
+	// should make one wait for the other, so that every migration is
+	// applied exactly once.
+	var wg sync.WaitGroup
+	applied := make([]int, 2)
+	errs := make([]error, 2)
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			done, err := m.Up(ctx)
+			applied[i], errs[i] = len(done), err
+		}(i)
+	}
+	wg.Wait()
+
+	assert.NilError(t, errs[0])
+	assert.NilError(t, errs[1])
+	assert.Equal(t, applied[0]+applied[1], len(m.Migrations))
+
+	statuses, err = m.Status(ctx)
+	assert.NilError(t, err)
+	for _, s := range statuses {
+		assert.Equal(t, s.Applied(), true)
+	}
+
+	// Revert the newest two migrations, and check that their tables have
+	// gone.
+	done, err := m.Down(ctx, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), 2)
+	assert.Equal(t, done[0].Version, m.Latest())
+
+	var exists bool
+	err = db.QueryRow("SELECT to_regclass('user_identities') IS NOT NULL").Scan(&exists)
+	assert.NilError(t, err)
+	assert.Equal(t, exists, false)
+
+	// Check that applying them again only applies the two.
+	done, err = m.Up(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), 2)
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	// Skip the test if the "-short" flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("migrations: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// Create the tables which databases had before migrations were
+	// introduced, without a schema_migrations table.
+	script, err := os.ReadFile("./testdata/baseline.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(string(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(db)
+	assert.NilError(t, err)
+
+	// Check that every migration applies on top of the existing tables.
+	done, err := m.Up(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), len(m.Migrations))
+
+	// Check that the existing rows have been kept, and given sensible values
+	// for the new columns.
+	var userID sql.NullString
+	var updatedOn, createdOn time.Time
+	var language string
+
+	err = db.QueryRow(`SELECT user_id, created_on, updated_on, language FROM snippets
+	WHERE id = '6ba7b810-9dad-11d1-80b4-00c04fd430c8'`).Scan(&userID, &createdOn, &updatedOn, &language)
+	assert.NilError(t, err)
+	assert.Equal(t, userID.Valid, false)
+	assert.Equal(t, updatedOn, createdOn)
+	assert.Equal(t, language, "plaintext")
+
+	var hashedPassword string
+	var isAdmin bool
+	var emailVerifiedOn sql.NullTime
+
+	err = db.QueryRow(`SELECT hashed_password, is_admin, email_verified_on FROM users
+	WHERE email = 'falso@example.com'`).Scan(&hashedPassword, &isAdmin, &emailVerifiedOn)
+	assert.NilError(t, err)
+	assert.Equal(t, hashedPassword, "$2a$12$D2ndhbqWL99PVZPZDNX5nuWLqVU3pMvdyuBaJxhTnn5UlFw6Bu4Bq")
+	assert.Equal(t, isAdmin, false)
+
+	// Existing users should count as verified, so that they can still
+	// create snippets.
+	assert.Equal(t, emailVerifiedOn.Valid, true)
+}
@@ -0,0 +1,34 @@
+-- The baseline is the schema from before migrations were introduced. It
+-- only creates what doesn't exist yet, so that existing databases (which
+-- already have these tables) can adopt the migrations.
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+  id uuid DEFAULT uuid_generate_v4() NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  email VARCHAR(255) NOT NULL,
+  hashed_password CHAR(60) NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT users_uc_email UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS snippets (
+  id uuid DEFAULT uuid_generate_v4() NOT NULL,
+  title VARCHAR(120) NOT NULL,
+  content TEXT NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  expires_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippets_created_on ON snippets(created_on);
+
+-- The sessions table is used by the scs PostgreSQL session store.
+CREATE TABLE IF NOT EXISTS sessions (
+  token TEXT PRIMARY KEY,
+  data BYTEA NOT NULL,
+  expiry TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry);
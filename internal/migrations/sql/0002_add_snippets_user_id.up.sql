@@ -0,0 +1,6 @@
+-- Snippets created before users were linked to them have no user.
+ALTER TABLE snippets ADD COLUMN user_id uuid;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_snippets_user_id ON snippets(user_id);
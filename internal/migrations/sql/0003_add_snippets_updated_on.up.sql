@@ -0,0 +1,8 @@
+-- Some databases were created with an updated_on column already, so only
+-- add it if it's missing. Snippets which have never been edited were last
+-- updated when they were created.
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS updated_on TIMESTAMP;
+
+UPDATE snippets SET updated_on = created_on WHERE updated_on IS NULL;
+
+ALTER TABLE snippets ALTER COLUMN updated_on SET NOT NULL;
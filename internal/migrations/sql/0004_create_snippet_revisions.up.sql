@@ -0,0 +1,9 @@
+CREATE TABLE snippet_revisions (
+  snippet_id uuid NOT NULL,
+  revision INTEGER NOT NULL,
+  title VARCHAR(120) NOT NULL,
+  content TEXT NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (snippet_id, revision),
+  CONSTRAINT fk_snippet_revisions_snippet_id FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
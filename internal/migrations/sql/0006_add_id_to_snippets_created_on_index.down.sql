@@ -0,0 +1,3 @@
+DROP INDEX idx_snippets_created_on;
+
+CREATE INDEX idx_snippets_created_on ON snippets(created_on);
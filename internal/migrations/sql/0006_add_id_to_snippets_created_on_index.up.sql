@@ -0,0 +1,5 @@
+-- Include the ID in the index, so that it covers the keyset pagination
+-- cursors.
+DROP INDEX idx_snippets_created_on;
+
+CREATE INDEX idx_snippets_created_on ON snippets(created_on, id);
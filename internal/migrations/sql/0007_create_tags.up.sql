@@ -0,0 +1,16 @@
+CREATE TABLE tags (
+  id uuid DEFAULT uuid_generate_v4() NOT NULL,
+  name VARCHAR(32) NOT NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT tags_uc_name UNIQUE (name)
+);
+
+CREATE TABLE snippet_tags (
+  snippet_id uuid NOT NULL,
+  tag_id uuid NOT NULL,
+  PRIMARY KEY (snippet_id, tag_id),
+  CONSTRAINT fk_snippet_tags_snippet_id FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+  CONSTRAINT fk_snippet_tags_tag_id FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_snippet_tags_tag_id ON snippet_tags(tag_id);
@@ -0,0 +1,14 @@
+CREATE TABLE tokens (
+  id uuid DEFAULT uuid_generate_v4() NOT NULL,
+  user_id uuid NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  scope VARCHAR(16) NOT NULL,
+  hash BYTEA NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  last_used_on TIMESTAMP,
+  PRIMARY KEY (id),
+  CONSTRAINT tokens_uc_hash UNIQUE (hash),
+  CONSTRAINT fk_tokens_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_tokens_user_id ON tokens(user_id);
@@ -0,0 +1,10 @@
+CREATE TABLE password_resets (
+  hash BYTEA NOT NULL,
+  user_id uuid NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  expires_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (hash),
+  CONSTRAINT fk_password_resets_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_password_resets_user_id ON password_resets(user_id);
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN email_verified_on TIMESTAMP;
+
+-- Treat the users who signed up before verification was introduced as
+-- verified, so that they aren't locked out of creating snippets.
+UPDATE users SET email_verified_on = created_on;
+
+CREATE TABLE email_verifications (
+  hash BYTEA NOT NULL,
+  user_id uuid NOT NULL,
+  email VARCHAR(255) NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  expires_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (hash),
+  CONSTRAINT fk_email_verifications_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_email_verifications_user_id ON email_verifications(user_id);
@@ -0,0 +1,15 @@
+CREATE TABLE two_factor (
+  user_id uuid NOT NULL,
+  secret VARCHAR(64) NOT NULL,
+  last_step BIGINT NOT NULL,
+  enabled_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id),
+  CONSTRAINT fk_two_factor_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+  user_id uuid NOT NULL,
+  hash BYTEA NOT NULL,
+  PRIMARY KEY (user_id, hash),
+  CONSTRAINT fk_recovery_codes_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
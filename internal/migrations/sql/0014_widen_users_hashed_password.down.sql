@@ -0,0 +1,6 @@
+-- Argon2id hashes don't fit in CHAR(60), and the code from before this
+-- migration can't check them anyway, so they're cleared. Those users will
+-- need to reset their password.
+UPDATE users SET hashed_password = '' WHERE length(hashed_password) > 60;
+
+ALTER TABLE users ALTER COLUMN hashed_password TYPE CHAR(60);
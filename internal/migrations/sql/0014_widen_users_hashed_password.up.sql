@@ -0,0 +1,3 @@
+-- Argon2id hashes are longer than bcrypt hashes, and don't have a fixed
+-- length.
+ALTER TABLE users ALTER COLUMN hashed_password TYPE VARCHAR(255);
@@ -0,0 +1,12 @@
+CREATE TABLE audit_events (
+  id uuid DEFAULT uuid_generate_v4() NOT NULL,
+  user_id uuid NOT NULL,
+  action VARCHAR(64) NOT NULL,
+  details TEXT NOT NULL,
+  ip_address VARCHAR(64) NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT fk_audit_events_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id, created_on);
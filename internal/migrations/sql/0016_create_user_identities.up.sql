@@ -0,0 +1,10 @@
+CREATE TABLE user_identities (
+  provider VARCHAR(32) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  user_id uuid NOT NULL,
+  created_on TIMESTAMP NOT NULL,
+  PRIMARY KEY (provider, subject),
+  CONSTRAINT fk_user_identities_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
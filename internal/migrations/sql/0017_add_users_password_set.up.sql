@@ -0,0 +1,3 @@
+-- Users created by single sign-on are given a random password which they
+-- never see, so record whether the user has chosen their own password.
+ALTER TABLE users ADD COLUMN password_set BOOLEAN NOT NULL DEFAULT TRUE;
@@ -1,6 +1,7 @@
--- CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
--- SELECT
---   uuid_generate_v4();
+-- The schema of a database from before migrations were introduced, with a
+-- user and a snippet in it.
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
 CREATE TABLE snippets (
   id uuid DEFAULT uuid_generate_v4() NOT NULL,
   title VARCHAR(120) NOT NULL,
@@ -26,6 +27,14 @@ ALTER TABLE
 ADD
   CONSTRAINT users_uc_email UNIQUE (email);
 
+CREATE TABLE sessions (
+  token TEXT PRIMARY KEY,
+  data BYTEA NOT NULL,
+  expiry TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON sessions (expiry);
+
 INSERT INTO
   users (id, name, email, hashed_password, created_on)
 VALUES
@@ -35,4 +44,15 @@ VALUES
     'falso@example.com',
     '$2a$12$D2ndhbqWL99PVZPZDNX5nuWLqVU3pMvdyuBaJxhTnn5UlFw6Bu4Bq',
     '2023-01-23 13:25:37.403671'
-  );
\ No newline at end of file
+  );
+
+INSERT INTO
+  snippets (id, title, content, created_on, expires_on)
+VALUES
+  (
+    '6ba7b810-9dad-11d1-80b4-00c04fd430c8',
+    'An old silent pond',
+    'An old silent pond...',
+    '2023-01-23 13:40:12.000000',
+    '2024-01-23 13:40:12.000000'
+  );
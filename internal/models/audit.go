@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Define the actions which are recorded in a user's audit trail.
+const (
+	AuditNameChanged          = "name_changed"
+	AuditEmailChangeRequested = "email_change_requested"
+	AuditEmailChanged         = "email_changed"
+)
+
+// Define an AuditModelInterface interface that describes the methods our
+// AuditModel has.
+type AuditModelInterface interface {
+	Record(userID uuid.UUID, action, details, ipAddress string) error
+	ForUser(userID uuid.UUID, limit int) ([]*AuditEvent, error)
+}
+
+// Define an AuditEvent type to hold a change made to a user's account. The
+// Details field holds a human-readable description of the change (ex: the
+// old and new names), and IPAddress the address the change was made from.
+type AuditEvent struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Action    string
+	Details   string
+	IPAddress string
+	CreatedOn time.Time
+}
+
+// Define an AuditModel type that wraps a database connection pool.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// The Record() method adds an event to a user's audit trail.
+func (m *AuditModel) Record(userID uuid.UUID, action, details, ipAddress string) error {
+	query := `INSERT INTO audit_events (user_id, action, details, ip_address, created_on)
+		VALUES ($1, $2, $3, $4, (now() at time zone 'utc'))`
+
+	_, err := m.DB.Exec(query, userID, action, details, ipAddress)
+	return err
+}
+
+// The ForUser() method returns up to limit of the most recent events in a
+// user's audit trail, newest first.
+func (m *AuditModel) ForUser(userID uuid.UUID, limit int) ([]*AuditEvent, error) {
+	query := `SELECT id, user_id, action, details, ip_address, created_on FROM audit_events
+		WHERE user_id = $1
+		ORDER BY created_on DESC, id
+		LIMIT $2`
+
+	rows, err := m.DB.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		e := &AuditEvent{}
+
+		err = rows.Scan(&e.ID, &e.UserID, &e.Action, &e.Details, &e.IPAddress, &e.CreatedOn)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -12,4 +12,17 @@ var (
 	// Add an ErrDuplicateEmail error that returns if a user tries to signup
 	// with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	// Add an ErrInvalidCursor error that returns if a pagination cursor can't
+	// be decoded.
+	ErrInvalidCursor = errors.New("models: invalid cursor")
+
+	// Add an ErrInvalidToken error that returns if a one-time token (ex: a
+	// password reset token) doesn't exist, has expired or was already used.
+	ErrInvalidToken = errors.New("models: invalid or expired token")
+
+	// Add an ErrUnverifiedEmail error that returns if a single sign-on
+	// identity can't be linked to an account, because the identity
+	// provider or the matching account hasn't verified the email address.
+	ErrUnverifiedEmail = errors.New("models: email address not verified")
 )
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/migrations"
+)
+
+// Define a HealthModelInterface interface that describes the methods our
+// HealthModel has.
+type HealthModelInterface interface {
+	Ping(ctx context.Context) error
+	MigrationStatus(ctx context.Context) (*MigrationStatus, error)
+}
+
+// Define a MigrationStatus type to hold whether the database schema is up to
+// date. Version is the newest migration which has been applied, Latest the
+// newest one in the binary, and Pending the number still to be applied.
+type MigrationStatus struct {
+	Version int64
+	Latest  int64
+	Pending int
+}
+
+// The Current() method reports whether all the migrations have been
+// applied.
+func (s *MigrationStatus) Current() bool {
+	return s.Pending == 0
+}
+
+// Define a HealthModel type that wraps a database connection pool, and the
+// migrations which should have been applied to it.
+type HealthModel struct {
+	DB       *sql.DB
+	Migrator *migrations.Migrator
+}
+
+// The Ping() method checks that a connection to the database can be made.
+func (m *HealthModel) Ping(ctx context.Context) error {
+	return m.DB.PingContext(ctx)
+}
+
+// The MigrationStatus() method checks which of the migrations have been
+// applied to the database.
+func (m *HealthModel) MigrationStatus(ctx context.Context) (*MigrationStatus, error) {
+	statuses, err := m.Migrator.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Latest: m.Migrator.Latest()}
+
+	for _, s := range statuses {
+		if s.Applied() {
+			status.Version = max(status.Version, s.Version)
+		} else {
+			status.Pending++
+		}
+	}
+
+	return status, nil
+}
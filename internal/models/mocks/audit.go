@@ -0,0 +1,46 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/google/uuid"
+)
+
+// Define an AuditModel which keeps the events in memory, so that the handler
+// tests can check what was recorded.
+type AuditModel struct {
+	mu     sync.Mutex
+	events []*models.AuditEvent
+}
+
+func (m *AuditModel) Record(userID uuid.UUID, action, details, ipAddress string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, &models.AuditEvent{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Action:    action,
+		Details:   details,
+		IPAddress: ipAddress,
+		CreatedOn: time.Now(),
+	})
+	return nil
+}
+
+func (m *AuditModel) ForUser(userID uuid.UUID, limit int) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []*models.AuditEvent{}
+
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		if m.events[i].UserID == userID {
+			events = append(events, m.events[i])
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,31 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+)
+
+// Define a HealthModel whose checks fail with PingErr, or report Pending
+// migrations, so that the readiness tests can simulate an unhealthy
+// database.
+type HealthModel struct {
+	PingErr error
+	Pending int
+}
+
+func (m *HealthModel) Ping(ctx context.Context) error {
+	return m.PingErr
+}
+
+func (m *HealthModel) MigrationStatus(ctx context.Context) (*models.MigrationStatus, error) {
+	if m.PingErr != nil {
+		return nil, m.PingErr
+	}
+
+	return &models.MigrationStatus{
+		Version: int64(11 - m.Pending),
+		Latest:  11,
+		Pending: m.Pending,
+	}, nil
+}
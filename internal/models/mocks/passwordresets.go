@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/google/uuid"
+)
+
+// Define the plain-text value of the mock password reset token.
+const ResetToken = "resettokenresettokenresettokenre"
+
+type PasswordResetModel struct{}
+
+func (m *PasswordResetModel) New(email string, ttl time.Duration) (string, error) {
+	if email == "falso@example.com" {
+		return ResetToken, nil
+	}
+
+	return "", models.ErrNoRecord
+}
+
+func (m *PasswordResetModel) Check(plaintext string) (uuid.UUID, error) {
+	if plaintext == ResetToken {
+		return uid, nil
+	}
+
+	return uuid.Nil, models.ErrInvalidToken
+}
+
+func (m *PasswordResetModel) Reset(plaintext, newPassword string) (uuid.UUID, error) {
+	if plaintext == ResetToken {
+		return uid, nil
+	}
+
+	return uuid.Nil, models.ErrInvalidToken
+}
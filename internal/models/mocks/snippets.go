@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Avixph/learn-go-snippetbox/internal/models"
@@ -9,15 +11,53 @@ import (
 
 var mockSnippet = &models.Snippet{
 	ID:        uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"),
+	UserID:    uid,
+	Author:    "Nom Falso",
 	Title:     "An old silent pond",
 	Content:   "An old silent pond...",
+	Language:  "plaintext",
 	CreatedOn: time.Now(),
+	UpdatedOn: time.Now(),
 	ExpiresOn: time.Now(),
+	Tags:      []string{"haiku", "poetry"},
+}
+
+// Define a second mock snippet which is owned by a different user, so that
+// we can test the ownership checks.
+var mockOtherSnippet = &models.Snippet{
+	ID:        uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8"),
+	UserID:    uuid.MustParse("6ba7b814-9dad-11d1-80b4-00c04fd430c8"),
+	Author:    "Otro Usuario",
+	Title:     "Over the wintry forest",
+	Content:   "Over the wintry forest...",
+	Language:  "go",
+	CreatedOn: time.Now(),
+	UpdatedOn: time.Now(),
+	ExpiresOn: time.Now(),
+}
+
+// Define two revisions of the first mock snippet, so that we can test the
+// history and diff pages.
+var mockRevisions = []*models.Revision{
+	{
+		SnippetID: mockSnippet.ID,
+		Number:    2,
+		Title:     "An old silent pond",
+		Content:   "An old silent pond...\nA frog jumps into the pond,",
+		CreatedOn: time.Now(),
+	},
+	{
+		SnippetID: mockSnippet.ID,
+		Number:    1,
+		Title:     "An old silent pond",
+		Content:   "An old silent pond...",
+		CreatedOn: time.Now(),
+	},
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title string, content string, expireVal int) (string, error) {
+func (m *SnippetModel) Insert(userID uuid.UUID, title string, content string, language string, tags []string, expireVal int) (string, error) {
 	return uuid.New().String(), nil
 	// return "9c1fe9ac-b67c-4ba5-9530-208ac6985e0d", nil
 }
@@ -26,11 +66,95 @@ func (m *SnippetModel) Get(id uuid.UUID) (*models.Snippet, error) {
 	switch id {
 	case uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"):
 		return mockSnippet, nil
+	case mockOtherSnippet.ID:
+		return mockOtherSnippet, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+func (m *SnippetModel) Latest(tags []string, after, before *models.Cursor, limit int) (*models.SnippetPage, error) {
+	page := &models.SnippetPage{
+		Snippets: []*models.Snippet{},
+	}
+
+	// Only include the mock snippet if it has all of the requested tags.
+	if hasTags(mockSnippet, tags) {
+		page.Snippets = append(page.Snippets, mockSnippet)
+	}
+
+	// Pretend that there is always an older page, and a newer one whenever a
+	// cursor was given, so that the pagination links can be tested.
+	page.Next = &models.Cursor{CreatedOn: mockSnippet.CreatedOn, ID: mockSnippet.ID}
+	if after != nil || before != nil {
+		page.Prev = &models.Cursor{CreatedOn: mockSnippet.CreatedOn, ID: mockSnippet.ID}
+	}
+
+	return page, nil
+}
+
+func (m *SnippetModel) ByAuthor(userID uuid.UUID) ([]*models.Snippet, error) {
+	if userID == uid {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) Update(id, userID uuid.UUID, title string, content string, language string, tags []string, expireVal int) error {
+	if id == mockSnippet.ID && userID == uid {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *SnippetModel) Delete(id, userID uuid.UUID) error {
+	if id == mockSnippet.ID && userID == uid {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *SnippetModel) Revisions(id uuid.UUID) ([]*models.Revision, error) {
+	if id == mockSnippet.ID {
+		return mockRevisions, nil
+	}
+
+	return []*models.Revision{}, nil
+}
+
+func (m *SnippetModel) Revision(id uuid.UUID, number int) (*models.Revision, error) {
+	for _, rev := range mockRevisions {
+		if rev.SnippetID == id && rev.Number == number {
+			return rev, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *SnippetModel) Search(query string, limit int) ([]*models.SearchResult, error) {
+	if strings.Contains(strings.ToLower(mockSnippet.Content), strings.ToLower(query)) {
+		result := &models.SearchResult{
+			Snippet: *mockSnippet,
+			Rank:    0.1,
+			Excerpt: "An old " + models.HighlightStart + "silent" + models.HighlightStop + " pond...",
+		}
+
+		return []*models.SearchResult{result}, nil
+	}
+
+	return []*models.SearchResult{}, nil
+}
+
+// The hasTags() helper returns true if the snippet has all of the given tags.
+func hasTags(s *models.Snippet, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(s.Tags, tag) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,63 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/google/uuid"
+)
+
+// Define the plain-text values of the mock tokens, so that the handler tests
+// can use them in their Authorization headers.
+const (
+	ReadToken  = "sbx_readtokenreadtokenreadtokenrea"
+	WriteToken = "sbx_writetokenwritetokenwritetoken"
+)
+
+var mockToken = &models.Token{
+	ID:        uuid.MustParse("6ba7b815-9dad-11d1-80b4-00c04fd430c8"),
+	UserID:    uid,
+	Name:      "CI deploys",
+	Scope:     models.ScopeWrite,
+	CreatedOn: time.Now(),
+}
+
+type TokenModel struct{}
+
+func (m *TokenModel) New(userID uuid.UUID, name, scope string) (*models.Token, error) {
+	return &models.Token{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		Plaintext: "sbx_newtokennewtokennewtokennewtok",
+		CreatedOn: time.Now(),
+	}, nil
+}
+
+func (m *TokenModel) ForUser(userID uuid.UUID) ([]*models.Token, error) {
+	if userID == uid {
+		return []*models.Token{mockToken}, nil
+	}
+
+	return []*models.Token{}, nil
+}
+
+func (m *TokenModel) Revoke(id, userID uuid.UUID) error {
+	if id == mockToken.ID && userID == uid {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *TokenModel) Authenticate(plaintext string) (*models.Token, error) {
+	switch plaintext {
+	case ReadToken:
+		return &models.Token{ID: uuid.New(), UserID: uid, Name: "Read", Scope: models.ScopeRead}, nil
+	case WriteToken:
+		return mockToken, nil
+	default:
+		return nil, models.ErrInvalidCredentials
+	}
+}
@@ -0,0 +1,50 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/google/uuid"
+)
+
+// Define the TOTP secret and a recovery code of the mock user who has enabled
+// two-factor authentication.
+const (
+	TwoFactorSecret = "JBSWY3DPEHPK3PXP"
+	RecoveryCode    = "aaaaa-bbbbb"
+)
+
+type TwoFactorModel struct{}
+
+func (m *TwoFactorModel) Get(userID uuid.UUID) (*models.TwoFactor, error) {
+	if userID == twoFactorUID {
+		return &models.TwoFactor{
+			UserID:            twoFactorUID,
+			Secret:            TwoFactorSecret,
+			EnabledOn:         time.Now(),
+			RecoveryCodesLeft: 10,
+		}, nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *TwoFactorModel) Enable(userID uuid.UUID, secret string, step int64, recoveryCodes []string) error {
+	return nil
+}
+
+func (m *TwoFactorModel) Disable(userID uuid.UUID) error {
+	return nil
+}
+
+func (m *TwoFactorModel) UseStep(userID uuid.UUID, step int64) error {
+	return nil
+}
+
+func (m *TwoFactorModel) UseRecoveryCode(userID uuid.UUID, code string) error {
+	if userID == twoFactorUID && code == RecoveryCode {
+		return nil
+	}
+
+	return models.ErrInvalidToken
+}
@@ -11,6 +11,19 @@ type UserModel struct{}
 
 var uid = uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
 
+// Define a second mock user who hasn't verified their email address yet.
+var unverifiedUID = uuid.MustParse("6ba7b816-9dad-11d1-80b4-00c04fd430c8")
+
+// Define a third mock user who has enabled two-factor authentication.
+var twoFactorUID = uuid.MustParse("6ba7b817-9dad-11d1-80b4-00c04fd430c8")
+
+// Define a fourth mock user who was created by single sign-on with the
+// "test" provider, and hasn't set a password.
+var ssoUID = uuid.MustParse("6ba7b818-9dad-11d1-80b4-00c04fd430c8")
+
+// Define the subject of the mock single sign-on user's identity.
+const SSOSubject = "sso-1"
+
 func (m *UserModel) Insert(name, email, password string) error {
 	switch email {
 	case "kopi@example.com":
@@ -25,13 +38,19 @@ func (m *UserModel) Authenticate(email, password string) (string, error) {
 		return uid.String(), nil
 		// return "6ba7b811-9dad-11d1-80b4-00c04fd430c8", nil
 	}
+	if email == "nuevo@example.com" && password == "1376p@$$w0rd8923" {
+		return unverifiedUID.String(), nil
+	}
+	if email == "dos@example.com" && password == "1376p@$$w0rd8923" {
+		return twoFactorUID.String(), nil
+	}
 
 	return uuid.Nil.String(), models.ErrInvalidCredentials
 }
 
 func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
 	switch id {
-	case uid:
+	case uid, unverifiedUID, twoFactorUID, ssoUID:
 		// case uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8"):
 		return true, nil
 	default:
@@ -40,12 +59,49 @@ func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
 }
 
 func (m *UserModel) Get(id uuid.UUID) (*models.User, error) {
-	if id == uid {
+	now := time.Now()
+
+	switch id {
+	case uid:
+		u := &models.User{
+			ID:              uid,
+			Name:            "Nom Falso",
+			Email:           "falso@example.com",
+			CreatedOn:       now,
+			EmailVerifiedOn: &now,
+			IsAdmin:         true,
+			PasswordSet:     true,
+		}
+
+		return u, nil
+	case twoFactorUID:
 		u := &models.User{
-			ID:        uid,
-			Name:      "Nom Falso",
-			Email:     "falso@example.com",
-			CreatedOn: time.Now(),
+			ID:              twoFactorUID,
+			Name:            "Dos Factores",
+			Email:           "dos@example.com",
+			CreatedOn:       now,
+			EmailVerifiedOn: &now,
+			PasswordSet:     true,
+		}
+
+		return u, nil
+	case ssoUID:
+		u := &models.User{
+			ID:              ssoUID,
+			Name:            "Uno Solo",
+			Email:           "sso@example.com",
+			CreatedOn:       now,
+			EmailVerifiedOn: &now,
+		}
+
+		return u, nil
+	case unverifiedUID:
+		u := &models.User{
+			ID:          unverifiedUID,
+			Name:        "Nuevo Usuario",
+			Email:       "nuevo@example.com",
+			CreatedOn:   now,
+			PasswordSet: true,
 		}
 
 		return u, nil
@@ -65,3 +121,80 @@ func (m *UserModel) PasswordUpdate(id uuid.UUID, currentPassword, newPassword st
 
 	return models.ErrNoRecord
 }
+
+func (m *UserModel) UpdateName(id uuid.UUID, name string) error {
+	switch id {
+	case uid, unverifiedUID, twoFactorUID, ssoUID:
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *UserModel) LinkIdentity(provider, subject, email, name string, emailVerified bool) (uuid.UUID, error) {
+	if !emailVerified {
+		return uuid.Nil, models.ErrUnverifiedEmail
+	}
+
+	switch email {
+	case "falso@example.com":
+		return uid, nil
+	case "dos@example.com":
+		return twoFactorUID, nil
+	case "nuevo@example.com":
+		return uuid.Nil, models.ErrUnverifiedEmail
+	case "sso@example.com":
+		return ssoUID, nil
+	}
+
+	return uuid.New(), nil
+}
+
+func (m *UserModel) Identities(id uuid.UUID) ([]*models.Identity, error) {
+	if id == ssoUID {
+		return []*models.Identity{{Provider: "test", Subject: SSOSubject, CreatedOn: time.Now()}}, nil
+	}
+
+	return []*models.Identity{}, nil
+}
+
+func (m *UserModel) CheckPassword(id uuid.UUID, password string) error {
+	switch id {
+	case uid, unverifiedUID, twoFactorUID:
+		if password != "1376p@$$w0rd8923" {
+			return models.ErrInvalidCredentials
+		}
+
+		return nil
+	case ssoUID:
+		return models.ErrInvalidCredentials
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *UserModel) Delete(id uuid.UUID, policy models.SnippetPolicy) error {
+	switch id {
+	case uid, unverifiedUID, twoFactorUID, ssoUID:
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *UserModel) Export(id uuid.UUID) (*models.UserExport, error) {
+	u, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &models.UserExport{
+		User:     u,
+		Snippets: []*models.Snippet{},
+	}
+	if id == uid {
+		export.Snippets = append(export.Snippets, mockSnippet)
+	}
+
+	return export, nil
+}
@@ -0,0 +1,55 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/models"
+	"github.com/google/uuid"
+)
+
+// Define the plain-text value of the mock email verification token.
+const VerificationToken = "verifytokenverifytokenverifytoke"
+
+// Define the plain-text values of the mock email change tokens. The first
+// changes the first mock user's address to nueva@example.com, while the
+// second is for an address which somebody else has taken in the meantime.
+const (
+	EmailChangeToken         = "changetokenchangetokenchangetoke"
+	EmailChangeTakenToken    = "takentokentakentokentakentokenta"
+	EmailChangeNewEmail      = "nueva@example.com"
+	emailChangeTakenNewEmail = "kopi@example.com"
+)
+
+type EmailVerificationModel struct{}
+
+func (m *EmailVerificationModel) New(email string, ttl time.Duration) (string, error) {
+	return VerificationToken, nil
+}
+
+func (m *EmailVerificationModel) Verify(plaintext string) (uuid.UUID, error) {
+	if plaintext == VerificationToken {
+		return unverifiedUID, nil
+	}
+
+	return uuid.Nil, models.ErrInvalidToken
+}
+
+func (m *EmailVerificationModel) NewEmailChange(userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	switch email {
+	case "falso@example.com", "nuevo@example.com", "dos@example.com", emailChangeTakenNewEmail:
+		return "", models.ErrDuplicateEmail
+	}
+
+	return EmailChangeToken, nil
+}
+
+func (m *EmailVerificationModel) ConfirmEmailChange(plaintext string) (*models.EmailChange, error) {
+	switch plaintext {
+	case EmailChangeToken:
+		return &models.EmailChange{UserID: uid, OldEmail: "falso@example.com", NewEmail: EmailChangeNewEmail}, nil
+	case EmailChangeTakenToken:
+		return nil, models.ErrDuplicateEmail
+	}
+
+	return nil, models.ErrInvalidToken
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Define a Cursor type which marks a position in a list of snippets ordered
+// by creation time. Because several snippets can share the same created_on
+// value, the snippet ID is used to break ties.
+type Cursor struct {
+	CreatedOn time.Time
+	ID        uuid.UUID
+}
+
+// The String() method encodes the cursor as an opaque, URL-safe string which
+// can be used in a query string.
+func (c Cursor) String() string {
+	raw := fmt.Sprintf("%d|%s", c.CreatedOn.UnixMicro(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// The ParseCursor() func decodes a cursor which was encoded by the String()
+// method. If the value is malformed an ErrInvalidCursor error is returned.
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	micro, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	us, err := strconv.ParseInt(micro, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	c := Cursor{CreatedOn: time.UnixMicro(us).UTC()}
+
+	c.ID, err = uuid.Parse(id)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// The cursorFor() func returns the cursor which points at a snippet.
+func cursorFor(s *Snippet) *Cursor {
+	return &Cursor{CreatedOn: s.CreatedOn, ID: s.ID}
+}
+
+// Define a SnippetPage type which holds one page of snippets, along with the
+// cursors needed to fetch the pages either side of it. Next points at the
+// last (oldest) snippet on the page and Prev at the first (newest); each is
+// nil if there is no page in that direction.
+type SnippetPage struct {
+	Snippets []*Snippet
+	Next     *Cursor
+	Prev     *Cursor
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/google/uuid"
+)
+
+func TestParseCursor(t *testing.T) {
+	valid := Cursor{
+		CreatedOn: time.Date(2023, 1, 23, 13, 25, 37, 403671000, time.UTC),
+		ID:        uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"),
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    Cursor
+		wantErr error
+	}{
+		{
+			name:   "Round trip",
+			cursor: valid.String(),
+			want:   valid,
+		},
+		{
+			name:    "Not base64",
+			cursor:  "not a cursor!",
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Missing ID",
+			cursor:  "MTIzNDU",
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Empty",
+			cursor:  "",
+			wantErr: ErrInvalidCursor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCursor(tt.cursor)
+
+			assert.Equal(t, err, tt.wantErr)
+			assert.Equal(t, c.ID, tt.want.ID)
+			assert.Equal(t, c.CreatedOn.Equal(tt.want.CreatedOn), true)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/password"
+	"github.com/google/uuid"
+)
+
+// Define a PasswordResetModelInterface interface that describes the methods
+// our PasswordResetModel has.
+type PasswordResetModelInterface interface {
+	New(email string, ttl time.Duration) (string, error)
+	Check(plaintext string) (uuid.UUID, error)
+	Reset(plaintext, newPassword string) (uuid.UUID, error)
+}
+
+// Define a PasswordResetModel type that wraps a database connection pool,
+// and the hasher used for the new passwords. Like API tokens, reset tokens
+// are only stored as a SHA-256 hash.
+type PasswordResetModel struct {
+	DB     *sql.DB
+	Hasher password.Hasher
+}
+
+// The New() method creates a reset token for the user with the given email
+// address, which expires after the given duration, and returns the
+// plain-text token. If no user has that email address the ErrNoRecord error
+// is returned.
+func (m *PasswordResetModel) New(email string, ttl time.Duration) (string, error) {
+	plaintext, hash, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO password_resets (hash, user_id, created_on, expires_on)
+		SELECT $1, id, (now() at time zone 'utc'), (now() at time zone 'utc') + $2 * interval '1 second'
+		FROM users WHERE email = $3`
+
+	result, err := m.DB.Exec(query, hash, int(ttl.Seconds()), email)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", ErrNoRecord
+	}
+
+	return plaintext, nil
+}
+
+// The Check() method returns the ID of the user that a reset token belongs
+// to, without using the token up. If the token doesn't exist or has expired
+// the ErrInvalidToken error is returned.
+func (m *PasswordResetModel) Check(plaintext string) (uuid.UUID, error) {
+	var userID uuid.UUID
+
+	query := `SELECT user_id FROM password_resets
+		WHERE hash = $1 AND expires_on > (now() at time zone 'utc')`
+
+	err := m.DB.QueryRow(query, hashToken(plaintext)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	return userID, nil
+}
+
+// The Reset() method uses up a reset token and sets the password of the user
+// it belongs to, returning the user's ID. All of the user's other reset
+// tokens are deleted at the same time, so that every outstanding link stops
+// working once the password has been changed. If the token doesn't exist or
+// has expired the ErrInvalidToken error is returned.
+func (m *PasswordResetModel) Reset(plaintext, newPassword string) (uuid.UUID, error) {
+	hashedPassword, err := passwordHasher(m.Hasher).Hash(newPassword)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	// Deleting the token and checking that it existed in a single statement
+	// makes sure that it can only be used once, even by concurrent requests.
+	var userID uuid.UUID
+
+	query := `DELETE FROM password_resets
+		WHERE hash = $1 AND expires_on > (now() at time zone 'utc')
+		RETURNING user_id`
+
+	err = tx.QueryRow(query, hashToken(plaintext)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET hashed_password = $1, password_set = TRUE WHERE id = $2`, hashedPassword, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = $1`, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -3,57 +3,144 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // Define a SnippetModelInterface interface that describes the methods our
 // SnippetModel has.
 type SnippetModelInterface interface {
-	Insert(title string, content string, expireVal int) (string, error)
+	Insert(userID uuid.UUID, title string, content string, language string, tags []string, expireVal int) (string, error)
 	Get(id uuid.UUID) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Latest(tags []string, after, before *Cursor, limit int) (*SnippetPage, error)
+	ByAuthor(userID uuid.UUID) ([]*Snippet, error)
+	Update(id, userID uuid.UUID, title string, content string, language string, tags []string, expireVal int) error
+	Delete(id, userID uuid.UUID) error
+	Revisions(id uuid.UUID) ([]*Revision, error)
+	Revision(id uuid.UUID, number int) (*Revision, error)
+	Search(query string, limit int) ([]*SearchResult, error)
 }
 
 // Define a Snippet type that holds data for individual snippets. Notice
 // how the feilds of the struct correspond to the feilds in our PostgreSQL
 // snippets table?
+// The UserID field links the snippet to the user who created it, and the
+// Author field holds that user's name (joined in from the users table). If
+// the user has deleted their account, UserID is uuid.Nil.
 type Snippet struct {
 	ID        uuid.UUID
+	UserID    uuid.UUID
+	Author    string
 	Title     string
 	Content   string
+	Language  string
 	CreatedOn time.Time
+	UpdatedOn time.Time
 	ExpiresOn time.Time
+	Tags      []string
 }
 
+// Define the columns we select for a snippet. The users table is joined (as
+// u) so that the author's name is returned alongside the snippet, and the
+// names of the snippet's tags are collected into an array. Snippets whose
+// author deleted their account (and chose to keep them) have no user, so
+// they're shown as anonymous.
+const snippetColumns = `s.id, s.user_id, COALESCE(u.name, 'Anonymous'), s.title, s.content, s.language, s.created_on, s.updated_on, s.expires_on,
+	ARRAY(SELECT t.name FROM snippet_tags st INNER JOIN tags t ON t.id = st.tag_id
+		WHERE st.snippet_id = s.id ORDER BY t.name)`
+
+// The scanDest() method returns pointers to the fields of the snippet in the
+// same order as the snippetColumns, ready to be passed to Scan(). We use
+// pq.Array() to scan the tag names into a string slice.
+func (s *Snippet) scanDest() []any {
+	return []any{&s.ID, &s.UserID, &s.Author, &s.Title, &s.Content, &s.Language, &s.CreatedOn, &s.UpdatedOn, &s.ExpiresOn, pq.Array(&s.Tags)}
+}
+
+// Define a Revision type that holds a copy of a snippet's title and content
+// as they were after a change. Every snippet starts at revision number 1 and
+// each update adds a new revision.
+type Revision struct {
+	SnippetID uuid.UUID
+	Number    int
+	Title     string
+	Content   string
+	CreatedOn time.Time
+}
+
+// Define a SearchResult type which holds a snippet matching a full-text
+// search, along with its rank and an excerpt of its content. The parts of the
+// excerpt that matched the search are wrapped in the HighlightStart and
+// HighlightStop markers, so that they can be highlighted when rendered.
+type SearchResult struct {
+	Snippet
+	Rank    float64
+	Excerpt string
+}
+
+// Define the markers that wrap the matching words in a search excerpt. We
+// use control characters rather than HTML tags, because the excerpt contains
+// untrusted user content which must still be escaped before it's displayed.
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
 // Define a SnippetModel type that wraps a sql.DB connection pool.
 type SnippetModel struct {
 	DB *sql.DB
 }
 
-// The Insert() method will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title string, content string, expireVal int) (string, error) {
+// The Insert() method will insert a new snippet, owned by the user with the
+// given ID and labelled with the given tags, into the database.
+func (m *SnippetModel) Insert(userID uuid.UUID, title string, content string, language string, tags []string, expireVal int) (string, error) {
 	// Define the SQL query we want to execute.
-	query := `INSERT INTO snippets (title, content, created_on, expires_on)
-		VALUES ($1, $2, (now() at time zone 'utc'), (now() at time zone 'utc' + $3 * interval '1 day'))
+	query := `INSERT INTO snippets (user_id, title, content, language, created_on, updated_on, expires_on)
+		VALUES ($1, $2, $3, $4, (now() at time zone 'utc'), (now() at time zone 'utc'), (now() at time zone 'utc' + $5 * interval '1 day'))
 		RETURNING id`
 
 	// Create an args slice containing the values for the placeholder
-	// parameters. The first parameter is the stmt var, followed by the
-	// title, content and the expiry values for the palceholder parameters.
-	// Declaring this slice next to our SQL query helps to make it nice and
-	// clear *what values are being used where* in the query.
-	args := []any{title, content, expireVal}
+	// parameters. The first parameter is the author's user ID, followed by
+	// the title, content, language and the expiry values for the palceholder
+	// parameters. Declaring this slice next to our SQL query helps to make it
+	// nice and clear *what values are being used where* in the query.
+	args := []any{userID, title, content, language, expireVal}
+
+	// Because we also need to record the first revision of the snippet, we
+	// begin a transaction so that either both rows are written or neither is.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return uuid.Nil.String(), err
+	}
+
+	// Defer a call to tx.Rollback(). If the transaction is committed this is a
+	// no-op, otherwise it ensures that any changes are discarded.
+	defer tx.Rollback()
 
 	// Create an id var with the type uuid.UUID
 	var id uuid.UUID
 
-	// Use the QueryRow() method to execute the SQL query on our connection
-	// pool, passing in args as a variadic parameter and scanning the
-	// generated id.
-	row := m.DB.QueryRow(query, args...)
-	err := row.Scan(&id)
+	// Use the QueryRow() method to execute the SQL query on our transaction,
+	// passing in args as a variadic parameter and scanning the generated id.
+	row := tx.QueryRow(query, args...)
+	err = row.Scan(&id)
+	if err != nil {
+		return uuid.Nil.String(), err
+	}
+
+	err = setTags(tx, id, tags)
+	if err != nil {
+		return uuid.Nil.String(), err
+	}
+
+	err = insertRevision(tx, id)
+	if err != nil {
+		return uuid.Nil.String(), err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return uuid.Nil.String(), err
 	}
@@ -63,14 +150,56 @@ func (m *SnippetModel) Insert(title string, content string, expireVal int) (stri
 	return id.String(), nil
 }
 
+// The setTags() func replaces the tags of a snippet with the given tag
+// names, creating any tags which don't exist yet. It must be called inside
+// the same transaction as the change to the snippet.
+func setTags(tx *sql.Tx, snippetID uuid.UUID, tags []string) error {
+	_, err := tx.Exec(`DELETE FROM snippet_tags WHERE snippet_id = $1`, snippetID)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	// Use ON CONFLICT DO NOTHING so that tags which are already in use by other
+	// snippets are left alone.
+	_, err = tx.Exec(`INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO snippet_tags (snippet_id, tag_id)
+		SELECT $1, id FROM tags WHERE name = ANY($2)`
+
+	_, err = tx.Exec(query, snippetID, pq.Array(tags))
+	return err
+}
+
+// The insertRevision() func copies the current title and content of a
+// snippet into the snippet_revisions table as its next revision. It must be
+// called inside the same transaction as the change it records.
+func insertRevision(tx *sql.Tx, snippetID uuid.UUID) error {
+	query := `INSERT INTO snippet_revisions (snippet_id, revision, title, content, created_on)
+		SELECT id, COALESCE((SELECT MAX(revision) FROM snippet_revisions WHERE snippet_id = $1), 0) + 1,
+			title, content, updated_on
+		FROM snippets WHERE id = $1`
+
+	_, err := tx.Exec(query, snippetID)
+	return err
+}
+
 // The Get() method will return a specific snippet from the database.
 func (m *SnippetModel) Get(id uuid.UUID) (*Snippet, error) {
 	// Initialize a pointer to a new zeroed Snippet struct.
 	s := &Snippet{}
 
-	// Define the SQL query we want to execute.
-	query := `SELECT id, title, content, created_on, expires_on FROM snippets
-	WHERE expires_on > now() AND id = $1`
+	// Define the SQL query we want to execute. The snippetColumns include the
+	// name of the snippet's author and its tags.
+	query := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.expires_on > now() AND s.id = $1`
 
 	// Use the QueryRow() method on the connection pool to execute the query,
 	// passing in the untrusted id variable as the value for the placeholder
@@ -83,7 +212,7 @@ func (m *SnippetModel) Get(id uuid.UUID) (*Snippet, error) {
 	// to row.Scan() are *pointers* to the place we want to copy the data
 	// into, and the number of arguments must be exactly the same as the
 	// number of columns returned by the statement.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.CreatedOn, &s.ExpiresOn)
+	err := row.Scan(s.scanDest()...)
 	if err != nil {
 		// If the query returns no rows, the row.Scan() will return a
 		// sql.ErrNoRows err. We use the errors.Is() func to check for that
@@ -99,15 +228,52 @@ func (m *SnippetModel) Get(id uuid.UUID) (*Snippet, error) {
 	return s, nil
 }
 
-// The Latest() method will return the 10 most recently created snippets.
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	// Define the SQL query we want to execute.
-	query := `SELECT id, title, content, created_on, expires_on FROM snippets
-	WHERE expires_on > now() ORDER BY id LIMIT 10`
+// The Latest() method will return a page of up to limit snippets, newest
+// first, optionally filtered to the snippets which have all of the given
+// tags. If the after cursor is given, the page holds the snippets created
+// before it (i.e. the next, older page); if the before cursor is given, it
+// holds the snippets created after it (i.e. the previous, newer page). We use
+// keyset pagination on (created_on, id), so fetching any page is as cheap as
+// fetching the first one.
+func (m *SnippetModel) Latest(tags []string, after, before *Cursor, limit int) (*SnippetPage, error) {
+	// Define the SQL query we want to execute. The WHERE clause and ordering
+	// depend on which direction we're paging in. We ask for one more row than
+	// the limit, so that we can tell whether there's another page beyond this
+	// one.
+	query := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.expires_on > now()`
+
+	args := []any{}
+
+	// If any tags are given, only include snippets which have *all* of them.
+	// We count the matching tags of each snippet and keep the snippets where
+	// the count equals the number of tags we're filtering by.
+	if len(tags) > 0 {
+		args = append(args, pq.Array(tags), len(tags))
+		query += ` AND s.id IN (SELECT st.snippet_id FROM snippet_tags st INNER JOIN tags t ON t.id = st.tag_id
+		WHERE t.name = ANY($1) GROUP BY st.snippet_id HAVING COUNT(*) = $2)`
+	}
+
+	// The placeholder numbers for the cursor depend on how many arguments
+	// were used by the tag filter.
+	n := len(args)
+
+	switch {
+	case after != nil:
+		query += fmt.Sprintf(` AND (s.created_on, s.id) < ($%d, $%d) ORDER BY s.created_on DESC, s.id DESC LIMIT $%d`, n+1, n+2, n+3)
+		args = append(args, after.CreatedOn, after.ID, limit+1)
+	case before != nil:
+		query += fmt.Sprintf(` AND (s.created_on, s.id) > ($%d, $%d) ORDER BY s.created_on ASC, s.id ASC LIMIT $%d`, n+1, n+2, n+3)
+		args = append(args, before.CreatedOn, before.ID, limit+1)
+	default:
+		query += fmt.Sprintf(` ORDER BY s.created_on DESC, s.id DESC LIMIT $%d`, n+1)
+		args = append(args, limit+1)
+	}
 
 	// Use the Query() method on the connection pool to execute the query.
 	// This returns a sql.Rows resultset containing the result of our query.
-	rows, err := m.DB.Query(query)
+	rows, err := m.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +301,7 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 		// () must be pointers to the place we want to copy the data into, and
 		// the number of arguments must be exactly the same as the number of
 		// columns returned by the statement.
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.CreatedOn, &s.ExpiresOn)
+		err := rows.Scan(s.scanDest()...)
 		if err != nil {
 			return nil, err
 		}
@@ -151,6 +317,239 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 		return nil, err
 	}
 
-	// If everything went ok, then return the Snippets slice.
+	page := &SnippetPage{}
+
+	// If we got back the extra row, there's another page in the direction
+	// we're paging in, so drop the extra row and remember that.
+	more := len(snippets) > limit
+	if more {
+		snippets = snippets[:limit]
+	}
+
+	// When paging backwards the rows come back oldest first, so reverse them
+	// to keep the page in newest first order.
+	if before != nil {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+
+	page.Snippets = snippets
+
+	if len(snippets) > 0 {
+		first, last := cursorFor(snippets[0]), cursorFor(snippets[len(snippets)-1])
+
+		// There's a newer page if we're paging backwards and found more rows,
+		// or if we're paging forwards from a cursor (the snippet the cursor
+		// points at is newer). The same logic applies the other way round for
+		// an older page.
+		if (before != nil && more) || after != nil {
+			page.Prev = first
+		}
+		if (before == nil && more) || before != nil {
+			page.Next = last
+		}
+	}
+
+	// If everything went ok, then return the page.
+	return page, nil
+}
+
+// The ByAuthor() method will return all of the unexpired snippets created by
+// a specific user, newest first.
+func (m *SnippetModel) ByAuthor(userID uuid.UUID) ([]*Snippet, error) {
+	query := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.expires_on > now() AND s.user_id = $1 ORDER BY s.created_on DESC`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+
+		err := rows.Scan(s.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return snippets, nil
 }
+
+// The Update() method will change the title, content, language, tags and
+// expiry of a snippet. An expireVal of 0 keeps the current expiry.
+// Only the snippet's author can update it, so if no unexpired snippet with
+// the given ID is owned by the user we return the ErrNoRecord error.
+func (m *SnippetModel) Update(id, userID uuid.UUID, title string, content string, language string, tags []string, expireVal int) error {
+	query := `UPDATE snippets SET title = $1, content = $2, language = $3,
+		expires_on = CASE WHEN $4 = 0 THEN expires_on
+			ELSE (now() at time zone 'utc' + $4 * interval '1 day') END,
+		updated_on = (now() at time zone 'utc')
+		WHERE id = $5 AND user_id = $6 AND expires_on > now()`
+
+	args := []any{title, content, language, expireVal, id, userID}
+
+	// Begin a transaction, so that the update and its revision are recorded
+	// together.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	// Use the RowsAffected() method to check whether a matching snippet was
+	// actually updated.
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	err = setTags(tx, id, tags)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method will remove a snippet owned by the given user from the
+// database. If no matching snippet exists we return the ErrNoRecord error.
+func (m *SnippetModel) Delete(id, userID uuid.UUID) error {
+	query := `DELETE FROM snippets WHERE id = $1 AND user_id = $2`
+
+	result, err := m.DB.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// The Revisions() method will return all of the revisions of a snippet,
+// newest first.
+func (m *SnippetModel) Revisions(id uuid.UUID) ([]*Revision, error) {
+	query := `SELECT snippet_id, revision, title, content, created_on FROM snippet_revisions
+	WHERE snippet_id = $1 ORDER BY revision DESC`
+
+	rows, err := m.DB.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+
+	for rows.Next() {
+		rev := &Revision{}
+
+		err := rows.Scan(&rev.SnippetID, &rev.Number, &rev.Title, &rev.Content, &rev.CreatedOn)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// The Revision() method will return a specific revision of a snippet.
+func (m *SnippetModel) Revision(id uuid.UUID, number int) (*Revision, error) {
+	rev := &Revision{}
+
+	query := `SELECT snippet_id, revision, title, content, created_on FROM snippet_revisions
+	WHERE snippet_id = $1 AND revision = $2`
+
+	row := m.DB.QueryRow(query, id, number)
+	err := row.Scan(&rev.SnippetID, &rev.Number, &rev.Title, &rev.Content, &rev.CreatedOn)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return rev, nil
+}
+
+// The Search() method will return up to limit unexpired snippets whose title
+// or content match the search query, best matches first.
+func (m *SnippetModel) Search(query string, limit int) ([]*SearchResult, error) {
+	// The search column holds a tsvector built from the title (weighted
+	// highest) and the content of each snippet. We use websearch_to_tsquery()
+	// so that users can type queries the way they would into a search engine
+	// (ex: "select join" -postgres), and ts_headline() to pick out the best
+	// matching fragments of the content. Any highlight markers in the content
+	// itself are removed first, so that only the ones ts_headline() adds are
+	// in the excerpt.
+	stmt := `SELECT ` + snippetColumns + `,
+		ts_rank(s.search, q) AS rank, ts_headline('english', translate(s.content, $4, ''), q, $2)
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id, websearch_to_tsquery('english', $1) q
+	WHERE s.expires_on > now() AND s.search @@ q
+	ORDER BY rank DESC, s.created_on DESC LIMIT $3`
+
+	options := "StartSel=" + HighlightStart + ", StopSel=" + HighlightStop + ", MaxFragments=3, MaxWords=20, MinWords=5"
+
+	rows, err := m.DB.Query(stmt, query, options, limit, HighlightStart+HighlightStop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+
+	for rows.Next() {
+		sr := &SearchResult{}
+
+		err := rows.Scan(append(sr.scanDest(), &sr.Rank, &sr.Excerpt)...)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, sr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
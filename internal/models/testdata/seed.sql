@@ -0,0 +1,11 @@
+INSERT INTO
+  users (id, name, email, hashed_password, created_on, email_verified_on)
+VALUES
+  (
+    '6ba7b811-9dad-11d1-80b4-00c04fd430c8',
+    'Nom Falso',
+    'falso@example.com',
+    '$2a$12$D2ndhbqWL99PVZPZDNX5nuWLqVU3pMvdyuBaJxhTnn5UlFw6Bu4Bq',
+    '2023-01-23 13:25:37.403671',
+    '2023-01-23 13:31:02.118204'
+  );
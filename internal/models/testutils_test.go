@@ -1,12 +1,14 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/migrations"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -33,20 +35,28 @@ func getEnvVariables(t *testing.T, key string) string {
 }
 
 func newTestDB(t *testing.T) *sql.DB {
-	// Establish a sql.DB connection pool for our test database. Because our
-	// setup and teardown scripts contain multiple SQL statements, we need to
-	// use the "multiStatement=true" parameter in our DSN. This instructs our
-	// PostgreSQL database driver to support executing multiple SQL statements
-	// in our db.Exec() call.
-	// dsn := flag.String("dsn", getTestEnvVariables(t, "TEST_DATABASE_URL"), "PostgresSQL data source name")
-	// db, err := sql.Open("postgres", dsn)
+	// Establish a sql.DB connection pool for our test database.
 	db, err := sql.Open("postgres", getEnvVariables(t, "TEST_DATABASE_URL"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// Read the setup SQL script from file and execute the statements.
-	script, err := os.ReadFile("./testdata/setup.sql")
+	// Create the schema by applying the same migrations as production, so
+	// that the test schema can't drift from it.
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the seed SQL script from file and execute the statements. Because
+	// the script is executed without arguments, it can contain multiple SQL
+	// statements.
+	script, err := os.ReadFile("./testdata/seed.sql")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,14 +67,10 @@ func newTestDB(t *testing.T) *sql.DB {
 
 	// Use the t.Cleanup() to register a func *which will automatically be
 	// called by Go when the current test/ sub-test, which calls newTestDB(),
-	// has finished. In this func we read and execute the teardown script, and
-	// close the database connection pool.
+	// has finished. In this func we revert all the migrations, and close the
+	// database connection pool.
 	t.Cleanup(func() {
-		script, err := os.ReadFile("./testdata/teardown.sql")
-		if err != nil {
-			t.Fatal(err)
-		}
-		_, err = db.Exec(string(script))
+		_, err := migrator.Down(context.Background(), len(migrator.Migrations))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Define the scopes which a personal API token can have. A read token can
+// only be used to authenticate requests which don't change any data, while
+// a write token can be used for everything.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Define the prefix which every plain-text token starts with. It makes
+// leaked tokens easy to recognise (ex: when scanning logs or repositories).
+const tokenPrefix = "sbx_"
+
+// Define a TokenModelInterface interface that describes the methods our
+// TokenModel has.
+type TokenModelInterface interface {
+	New(userID uuid.UUID, name, scope string) (*Token, error)
+	ForUser(userID uuid.UUID) ([]*Token, error)
+	Revoke(id, userID uuid.UUID) error
+	Authenticate(plaintext string) (*Token, error)
+}
+
+// Define a Token type to hold the data for a personal API token. Only the
+// SHA-256 hash of the token is stored in the database, so the Plaintext
+// field is only set on the token returned by New().
+type Token struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	Scope      string
+	Plaintext  string
+	CreatedOn  time.Time
+	LastUsedOn *time.Time
+}
+
+// The Allows() method reports whether the token grants the given scope. Write
+// tokens also grant the read scope.
+func (t *Token) Allows(scope string) bool {
+	return t.Scope == scope || t.Scope == ScopeWrite
+}
+
+// Define a TokenModel type that wraps a database connection pool.
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// The generateToken() func creates a new random plain-text token with the
+// given prefix, and returns it along with its hash. The token contains 20
+// random bytes (160 bits), encoded as base32 so that it can be pasted into a
+// shell or URL without quoting.
+func generateToken(prefix string) (string, []byte, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := prefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+
+	return plaintext, hashToken(plaintext), nil
+}
+
+// The hashToken() func returns the SHA-256 hash of a plain-text token. The
+// tokens are long and random, so a fast unsalted hash is sufficient here
+// (unlike passwords, which need bcrypt).
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// The New() method creates a new token for the given user, and returns it
+// with the Plaintext field set. This is the only time that the plain-text
+// token is available.
+func (m *TokenModel) New(userID uuid.UUID, name, scope string) (*Token, error) {
+	plaintext, hash, err := generateToken(tokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Token{
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		Plaintext: plaintext,
+	}
+
+	query := `INSERT INTO tokens (user_id, name, scope, hash, created_on)
+		VALUES ($1, $2, $3, $4, (now() at time zone 'utc'))
+		RETURNING id, created_on`
+
+	err = m.DB.QueryRow(query, userID, name, scope, hash).Scan(&t.ID, &t.CreatedOn)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// The ForUser() method returns all of the tokens belonging to a user, newest
+// first.
+func (m *TokenModel) ForUser(userID uuid.UUID) ([]*Token, error) {
+	query := `SELECT id, user_id, name, scope, created_on, last_used_on FROM tokens
+		WHERE user_id = $1
+		ORDER BY created_on DESC`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		t := &Token{}
+
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.CreatedOn, &t.LastUsedOn)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// The Revoke() method deletes a token. The user ID is part of the WHERE
+// clause so that users can only revoke their own tokens. If no matching
+// token exists the ErrNoRecord error is returned.
+func (m *TokenModel) Revoke(id, userID uuid.UUID) error {
+	query := `DELETE FROM tokens WHERE id = $1 AND user_id = $2`
+
+	result, err := m.DB.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// The Authenticate() method looks up the token matching a plain-text token
+// and records that it has been used. If the token doesn't exist (or has been
+// revoked) the ErrInvalidCredentials error is returned.
+func (m *TokenModel) Authenticate(plaintext string) (*Token, error) {
+	if !strings.HasPrefix(plaintext, tokenPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+
+	query := `UPDATE tokens SET last_used_on = (now() at time zone 'utc')
+		WHERE hash = $1
+		RETURNING id, user_id, name, scope, created_on, last_used_on`
+
+	t := &Token{}
+
+	err := m.DB.QueryRow(query, hashToken(plaintext)).Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.CreatedOn, &t.LastUsedOn)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
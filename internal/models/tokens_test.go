@@ -0,0 +1,49 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+func TestGenerateToken(t *testing.T) {
+	plaintext, hash, err := generateToken(tokenPrefix)
+	assert.NilError(t, err)
+
+	// Check that the token has the expected prefix and length (4 characters
+	// of prefix plus 32 characters of base32 encoded random bytes).
+	assert.Equal(t, strings.HasPrefix(plaintext, tokenPrefix), true)
+	assert.Equal(t, len(plaintext), 36)
+
+	// Check that the returned hash matches the plain-text token, and that a
+	// second token is different to the first.
+	assert.Equal(t, bytes.Equal(hash, hashToken(plaintext)), true)
+
+	other, _, err := generateToken(tokenPrefix)
+	assert.NilError(t, err)
+	assert.Equal(t, other == plaintext, false)
+}
+
+func TestTokenAllows(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		scope string
+		want  bool
+	}{
+		{"Read token, read scope", ScopeRead, ScopeRead, true},
+		{"Read token, write scope", ScopeRead, ScopeWrite, false},
+		{"Write token, read scope", ScopeWrite, ScopeRead, true},
+		{"Write token, write scope", ScopeWrite, ScopeWrite, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &Token{Scope: tt.token}
+
+			assert.Equal(t, token.Allows(tt.scope), tt.want)
+		})
+	}
+}
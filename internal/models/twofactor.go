@@ -0,0 +1,188 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Define a TwoFactorModelInterface interface that describes the methods our
+// TwoFactorModel has.
+type TwoFactorModelInterface interface {
+	Get(userID uuid.UUID) (*TwoFactor, error)
+	Enable(userID uuid.UUID, secret string, step int64, recoveryCodes []string) error
+	Disable(userID uuid.UUID) error
+	UseStep(userID uuid.UUID, step int64) error
+	UseRecoveryCode(userID uuid.UUID, code string) error
+}
+
+// Define a TwoFactor type to hold a user's TOTP two-factor authentication
+// settings. LastStep is the time step of the last code which was used, so
+// that a code can't be used twice.
+type TwoFactor struct {
+	UserID            uuid.UUID
+	Secret            string
+	LastStep          int64
+	EnabledOn         time.Time
+	RecoveryCodesLeft int
+}
+
+// Define a TwoFactorModel type that wraps a database connection pool.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// The GenerateRecoveryCodes() func returns n new random recovery codes. Each
+// code contains 50 random bits, formatted as two groups of five lower case
+// base32 characters (ex: "k3m7q-x2bd9").
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+
+	return codes, nil
+}
+
+// The normalizeRecoveryCode() func removes the formatting from a recovery
+// code, so that codes typed with spaces, without the dash or in upper case
+// are still accepted.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// The Get() method returns a user's two-factor settings. If the user hasn't
+// enabled two-factor authentication the ErrNoRecord error is returned.
+func (m *TwoFactorModel) Get(userID uuid.UUID) (*TwoFactor, error) {
+	tf := &TwoFactor{}
+
+	query := `SELECT t.user_id, t.secret, t.last_step, t.enabled_on,
+			(SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = t.user_id)
+		FROM two_factor t WHERE t.user_id = $1`
+
+	err := m.DB.QueryRow(query, userID).Scan(&tf.UserID, &tf.Secret, &tf.LastStep, &tf.EnabledOn, &tf.RecoveryCodesLeft)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return tf, nil
+}
+
+// The Enable() method turns on two-factor authentication for a user with the
+// given secret, recording step as the last used time step (since a code is
+// needed to confirm enrollment). Only hashes of the recovery codes are
+// stored. Any existing settings and recovery codes are replaced.
+func (m *TwoFactorModel) Enable(userID uuid.UUID, secret string, step int64, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO two_factor (user_id, secret, last_step, enabled_on)
+		VALUES ($1, $2, $3, (now() at time zone 'utc'))
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_step = EXCLUDED.last_step, enabled_on = EXCLUDED.enabled_on`
+
+	_, err = tx.Exec(query, userID, secret, step)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The Disable() method turns off two-factor authentication for a user, and
+// deletes their recovery codes.
+func (m *TwoFactorModel) Disable(userID uuid.UUID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The UseStep() method records that the code for a time step has been used.
+// If a code for the same or a later step was already used, the
+// ErrInvalidToken error is returned, which stops codes from being replayed.
+func (m *TwoFactorModel) UseStep(userID uuid.UUID, step int64) error {
+	query := `UPDATE two_factor SET last_step = $1 WHERE user_id = $2 AND last_step < $1`
+
+	result, err := m.DB.Exec(query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// The UseRecoveryCode() method uses up one of a user's recovery codes. If the
+// code doesn't exist (or was already used) the ErrInvalidToken error is
+// returned.
+func (m *TwoFactorModel) UseRecoveryCode(userID uuid.UUID, code string) error {
+	query := `DELETE FROM recovery_codes WHERE user_id = $1 AND hash = $2`
+
+	result, err := m.DB.Exec(query, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), 10)
+
+	rx := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := map[string]bool{}
+
+	for _, code := range codes {
+		assert.Equal(t, rx.MatchString(code), true)
+		assert.Equal(t, seen[code], false)
+		seen[code] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"Formatted", "k3m7q-x2bd6"},
+		{"Upper case", "K3M7Q-X2BD6"},
+		{"No dash", "k3m7qx2bd6"},
+		{"Spaces", " k3m7q x2bd6 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, normalizeRecoveryCode(tt.code), "k3m7qx2bd6")
+		})
+	}
+}
@@ -7,9 +7,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Avixph/learn-go-snippetbox/internal/password"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Define a UserModelInterface interface that describes the methods our
@@ -20,26 +20,79 @@ type UserModelInterface interface {
 	Exists(id uuid.UUID) (bool, error)
 	Get(id uuid.UUID) (*User, error)
 	PasswordUpdate(id uuid.UUID, currentPassord, newPassword string) error
+	UpdateName(id uuid.UUID, name string) error
+	CheckPassword(id uuid.UUID, password string) error
+	LinkIdentity(provider, subject, email, name string, emailVerified bool) (uuid.UUID, error)
+	Identities(id uuid.UUID) ([]*Identity, error)
+	Delete(id uuid.UUID, policy SnippetPolicy) error
+	Export(id uuid.UUID) (*UserExport, error)
 }
 
-// Define a User type.
+// Define a SnippetPolicy type for what happens to a user's snippets when
+// they delete their account. The snippets are either deleted along with the
+// account, or kept without any link to the user.
+type SnippetPolicy string
+
+const (
+	DeleteSnippets    SnippetPolicy = "delete"
+	AnonymizeSnippets SnippetPolicy = "anonymize"
+)
+
+// Define a UserExport type to hold a copy of all the data we store about a
+// user, for them to download.
+type UserExport struct {
+	User     *User
+	Snippets []*Snippet
+}
+
+// Define a User type. PasswordSet is false for users created by single
+// sign-on who haven't chosen a password of their own yet.
 type User struct {
-	ID             uuid.UUID
-	Name           string
-	Email          string
-	HashedPassword []byte
-	CreatedOn      time.Time
+	ID              uuid.UUID
+	Name            string
+	Email           string
+	HashedPassword  []byte
+	CreatedOn       time.Time
+	EmailVerifiedOn *time.Time
+	IsAdmin         bool
+	PasswordSet     bool
+}
+
+// Define an Identity type to hold an account at a single sign-on provider
+// which is linked to a user.
+type Identity struct {
+	Provider  string
+	Subject   string
+	CreatedOn time.Time
+}
+
+// The EmailVerified() method reports whether the user has confirmed that
+// they own their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedOn != nil
 }
 
-// Define a UserModel type that wraps a database connection pool.
+// Define a UserModel type that wraps a database connection pool, and the
+// hasher used for passwords. If Hasher is nil, password.DefaultArgon2id is
+// used.
 type UserModel struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Hasher password.Hasher
+}
+
+// The passwordHasher() func returns the given hasher, or the default one if
+// it's nil.
+func passwordHasher(h password.Hasher) password.Hasher {
+	if h == nil {
+		return password.DefaultArgon2id
+	}
+	return h
 }
 
 // The Insert() method will add a new recod to the "users" table
-func (m *UserModel) Insert(name, email, password string) error {
-	// Create a bcrypt hash of the plain-text password.
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (m *UserModel) Insert(name, email, plaintext string) error {
+	// Hash the plain-text password.
+	hashedPassword, err := passwordHasher(m.Hasher).Hash(plaintext)
 	if err != nil {
 		return err
 	}
@@ -77,13 +130,14 @@ func (m *UserModel) Insert(name, email, password string) error {
 
 // The Authenticate() method will verify whether a user with the
 // provided email and password exists. If they do the relevent user
-// ID will be returned.
-func (m *UserModel) Authenticate(email, password string) (string, error) {
+// ID will be returned. If the user's password hash uses an older algorithm
+// or weaker parameters, it's replaced with a new hash.
+func (m *UserModel) Authenticate(email, plaintext string) (string, error) {
 	// Retrieve the id and hashed password associated withthe given email.
 	// If no matching email exists then we return the ErrInvalidCredentials
 	// error.
 	var id uuid.UUID
-	var hashedPassword []byte
+	var hashedPassword string
 
 	query := `SELECT id, hashed_password FROM users WHERE email = $1`
 
@@ -99,15 +153,35 @@ func (m *UserModel) Authenticate(email, password string) (string, error) {
 
 	// Check whether the hashed password and plain-text password match. If
 	// they don't, we return the ErrInvalidCredentials error.
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	hasher := passwordHasher(m.Hasher)
+
+	needsRehash, err := hasher.Compare(hashedPassword, plaintext)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		if errors.Is(err, password.ErrMismatchedPassword) {
 			return uuid.Nil.String(), ErrInvalidCredentials
 		} else {
 			return uuid.Nil.String(), err
 		}
 	}
 
+	// Now that we know the plain-text password, we can upgrade an old hash
+	// (ex: a bcrypt hash from before we switched to argon2id). The WHERE
+	// clause makes sure we don't overwrite a password which has been changed
+	// in the meantime.
+	if needsRehash {
+		newHashedPassword, err := hasher.Hash(plaintext)
+		if err != nil {
+			return uuid.Nil.String(), err
+		}
+
+		query = `UPDATE users SET hashed_password = $1 WHERE id = $2 AND hashed_password = $3`
+
+		_, err = m.DB.Exec(query, newHashedPassword, id, hashedPassword)
+		if err != nil {
+			return uuid.Nil.String(), err
+		}
+	}
+
 	// Else, the password is correct and return the ID
 	return id.String(), nil
 }
@@ -132,10 +206,10 @@ func (m *UserModel) Get(id uuid.UUID) (*User, error) {
 	u := &User{}
 
 	// Define the sql query to retrive the user.
-	query := `SELECT id, name, email, created_on FROM users WHERE id = $1`
+	query := `SELECT id, name, email, created_on, email_verified_on, is_admin, password_set FROM users WHERE id = $1`
 
 	row := m.DB.QueryRow(query, id)
-	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedOn)
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedOn, &u.EmailVerifiedOn, &u.IsAdmin, &u.PasswordSet)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -148,40 +222,286 @@ func (m *UserModel) Get(id uuid.UUID) (*User, error) {
 }
 
 func (m *UserModel) PasswordUpdate(id uuid.UUID, currentPassword, newPassword string) error {
-	var currentHashedPassword []byte
+	var currentHashedPassword string
 
 	query := `SELECT hashed_password FROM users WHERE id = $1`
 
 	row := m.DB.QueryRow(query, id)
 	err := row.Scan(&currentHashedPassword)
-	fmt.Printf("Your row.Scan(&currentHashedPassword): \n%v", err)
 	if err != nil {
 		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword(currentHashedPassword, []byte(currentPassword))
+	hasher := passwordHasher(m.Hasher)
+
+	_, err = hasher.Compare(currentHashedPassword, currentPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		if errors.Is(err, password.ErrMismatchedPassword) {
 			return ErrInvalidCredentials
 		} else {
 			return err
 		}
 	}
 
-	hashedPaswordUpdate, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	hashedPaswordUpdate, err := hasher.Hash(newPassword)
 	if err != nil {
 		return err
 	}
 
 	query = `UPDATE users SET hashed_password = $1 WHERE id = $2 RETURNING id`
 
-	args := []any{string(hashedPaswordUpdate), id}
+	args := []any{hashedPaswordUpdate, id}
 
 	var email string
 
 	row = m.DB.QueryRow(query, args...)
 	err = row.Scan(&email)
-	fmt.Printf("Your row.Scan(&email): \n%v", err)
 
 	return err
 }
+
+// The UpdateName() method changes a user's display name. If no user with the
+// given ID exists the ErrNoRecord error is returned.
+func (m *UserModel) UpdateName(id uuid.UUID, name string) error {
+	result, err := m.DB.Exec(`UPDATE users SET name = $1 WHERE id = $2`, name, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// The LinkIdentity() method returns the ID of the user who logs in with the
+// given single sign-on identity. The first time an identity is used, it's
+// linked to the user with the same email address, or a new user is created
+// if there isn't one. New users get a random password, which they can
+// replace with a password reset if they want to log in without the identity
+// provider. Until they do, their PasswordSet field is false.
+//
+// Linking by email address is only safe when both sides have verified it:
+// otherwise somebody could sign up with another person's address, and later
+// get access to the account that person creates through single sign-on. In
+// that case the ErrUnverifiedEmail error is returned.
+func (m *UserModel) LinkIdentity(provider, subject, email, name string, emailVerified bool) (uuid.UUID, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var id uuid.UUID
+
+	query := `SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`
+
+	err = tx.QueryRow(query, provider, subject).Scan(&id)
+	if err == nil {
+		return id, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, err
+	}
+
+	if !emailVerified {
+		return uuid.Nil, ErrUnverifiedEmail
+	}
+
+	var emailVerifiedOn *time.Time
+
+	query = `SELECT id, email_verified_on FROM users WHERE email = $1`
+
+	err = tx.QueryRow(query, email).Scan(&id, &emailVerifiedOn)
+	switch {
+	case err == nil:
+		if emailVerifiedOn == nil {
+			return uuid.Nil, ErrUnverifiedEmail
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		plaintext, _, err := generateToken("")
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		hashedPassword, err := passwordHasher(m.Hasher).Hash(plaintext)
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		query = `INSERT INTO users (name, email, hashed_password, created_on, email_verified_on, password_set)
+			VALUES ($1, $2, $3, (now() at time zone 'utc'), (now() at time zone 'utc'), FALSE)
+			RETURNING id`
+
+		err = tx.QueryRow(query, name, email, hashedPassword).Scan(&id)
+		if err != nil {
+			var pSQLError *pq.Error
+			if errors.As(err, &pSQLError) {
+				if pSQLError.Code == "23505" && strings.Contains(pSQLError.Message, "users_uc_email") {
+					return uuid.Nil, ErrDuplicateEmail
+				}
+			}
+			return uuid.Nil, err
+		}
+	default:
+		return uuid.Nil, err
+	}
+
+	query = `INSERT INTO user_identities (provider, subject, user_id, created_on)
+		VALUES ($1, $2, $3, (now() at time zone 'utc'))`
+
+	_, err = tx.Exec(query, provider, subject, id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, tx.Commit()
+}
+
+// The Identities() method returns the single sign-on identities which are
+// linked to a user, oldest first.
+func (m *UserModel) Identities(id uuid.UUID) ([]*Identity, error) {
+	query := `SELECT provider, subject, created_on FROM user_identities
+	WHERE user_id = $1 ORDER BY created_on, provider`
+
+	rows, err := m.DB.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		i := &Identity{}
+
+		err = rows.Scan(&i.Provider, &i.Subject, &i.CreatedOn)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// The CheckPassword() method checks a user's password, before they're
+// allowed to do something which can't be undone (ex: delete their account).
+// If the password is wrong the ErrInvalidCredentials error is returned, and
+// if no user with the given ID exists the ErrNoRecord error is returned.
+func (m *UserModel) CheckPassword(id uuid.UUID, plaintext string) error {
+	var hashedPassword string
+
+	query := `SELECT hashed_password FROM users WHERE id = $1`
+
+	err := m.DB.QueryRow(query, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	_, err = passwordHasher(m.Hasher).Compare(hashedPassword, plaintext)
+	if err != nil {
+		if errors.Is(err, password.ErrMismatchedPassword) {
+			return ErrInvalidCredentials
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method removes a user. The caller is responsible for
+// checking that the user really wants to (ex: with CheckPassword()). The
+// rows which belong to the user (ex: their tokens and two-factor settings)
+// are removed by the ON DELETE CASCADE foreign keys. Their snippets are
+// deleted too, unless the policy is AnonymizeSnippets, in which case the
+// snippets are first unlinked from the user. If no user with the given ID
+// exists the ErrNoRecord error is returned.
+func (m *UserModel) Delete(id uuid.UUID, policy SnippetPolicy) error {
+	if policy != DeleteSnippets && policy != AnonymizeSnippets {
+		return fmt.Errorf("models: unknown snippet policy %q", policy)
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if policy == AnonymizeSnippets {
+		_, err = tx.Exec(`UPDATE snippets SET user_id = NULL WHERE user_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
+
+// The Export() method returns the user's details along with all of their
+// snippets, including the expired ones, oldest first.
+func (m *UserModel) Export(id uuid.UUID) (*UserExport, error) {
+	user, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.user_id = $1 ORDER BY s.created_on, s.id`
+
+	rows, err := m.DB.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	export := &UserExport{
+		User:     user,
+		Snippets: []*Snippet{},
+	}
+
+	for rows.Next() {
+		s := &Snippet{}
+
+		err := rows.Scan(s.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+
+		export.Snippets = append(export.Snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
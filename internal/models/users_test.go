@@ -1,9 +1,11 @@
 package models
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/password"
 	"github.com/google/uuid"
 )
 
@@ -44,7 +46,7 @@ func TestUserModelExists(t *testing.T) {
 			db := newTestDB(t)
 
 			// Create a new instance of the UserModel.
-			m := UserModel{db}
+			m := UserModel{DB: db}
 
 			// Call the UserModel.Exists() method and check that the return value and
 			// error match the expected values for the sub-test.
@@ -56,3 +58,194 @@ func TestUserModelExists(t *testing.T) {
 	}
 
 }
+
+func TestUserModelAuthenticateRehash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	// Use cheap argon2id parameters, so that the test runs quickly.
+	m := UserModel{DB: db, Hasher: &password.Argon2id{
+		Memory:      64,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}}
+
+	// The seeded user has a bcrypt hash, which should be replaced with an
+	// argon2id hash on the first successful login.
+	id, err := m.Authenticate("falso@example.com", "1376p@$$w0rd8923")
+	assert.NilError(t, err)
+	assert.Equal(t, id, "6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	var hash string
+	err = db.QueryRow(`SELECT hashed_password FROM users WHERE id = $1`, id).Scan(&hash)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), true)
+
+	// The new hash should still work, and a wrong password shouldn't.
+	_, err = m.Authenticate("falso@example.com", "1376p@$$w0rd8923")
+	assert.NilError(t, err)
+
+	_, err = m.Authenticate("falso@example.com", "wrong password")
+	assert.Equal(t, err, ErrInvalidCredentials)
+}
+
+func TestUserModelDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	userID := uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	tests := []struct {
+		name         string
+		userID       uuid.UUID
+		policy       SnippetPolicy
+		wantErr      error
+		wantUser     bool
+		wantSnippets int
+	}{
+		{
+			name:         "Delete snippets",
+			userID:       userID,
+			policy:       DeleteSnippets,
+			wantSnippets: 0,
+		},
+		{
+			name:         "Anonymize snippets",
+			userID:       userID,
+			policy:       AnonymizeSnippets,
+			wantSnippets: 1,
+		},
+		{
+			name:         "Unknown user",
+			userID:       uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8"),
+			policy:       DeleteSnippets,
+			wantErr:      ErrNoRecord,
+			wantUser:     true,
+			wantSnippets: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+
+			m := UserModel{DB: db}
+
+			_, err := db.Exec(`INSERT INTO snippets (user_id, title, content, created_on, updated_on, expires_on)
+				VALUES ($1, 'Haiku', 'A frog jumps', now(), now(), now() + INTERVAL '1 day')`, userID)
+			assert.NilError(t, err)
+
+			err = m.Delete(tt.userID, tt.policy)
+			assert.Equal(t, err, tt.wantErr)
+
+			exists, err := m.Exists(userID)
+			assert.NilError(t, err)
+			assert.Equal(t, exists, tt.wantUser)
+
+			var count int
+			err = db.QueryRow(`SELECT COUNT(*) FROM snippets`).Scan(&count)
+			assert.NilError(t, err)
+			assert.Equal(t, count, tt.wantSnippets)
+		})
+	}
+}
+
+func TestUserModelCheckPassword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	tests := []struct {
+		name     string
+		userID   uuid.UUID
+		password string
+		wantErr  error
+	}{
+		{
+			name:     "Valid password",
+			userID:   uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8"),
+			password: "1376p@$$w0rd8923",
+		},
+		{
+			name:     "Wrong password",
+			userID:   uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8"),
+			password: "wrong password",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Unknown user",
+			userID:   uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8"),
+			password: "1376p@$$w0rd8923",
+			wantErr:  ErrNoRecord,
+		},
+	}
+
+	db := newTestDB(t)
+
+	m := UserModel{DB: db}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.CheckPassword(tt.userID, tt.password)
+			assert.Equal(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestUserModelLinkIdentity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := UserModel{DB: db, Hasher: &password.Argon2id{
+		Memory:      64,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}}
+
+	// An identity with the seeded user's verified email address is linked
+	// to them, and keeps working if the email address changes later.
+	id, err := m.LinkIdentity("corp", "sub-1", "falso@example.com", "Nom Falso", true)
+	assert.NilError(t, err)
+	assert.Equal(t, id.String(), "6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	again, err := m.LinkIdentity("corp", "sub-1", "changed@example.com", "Nom Falso", false)
+	assert.NilError(t, err)
+	assert.Equal(t, again, id)
+
+	// An unverified email address can't be used to link or create a user.
+	_, err = m.LinkIdentity("corp", "sub-2", "falso@example.com", "Nom Falso", false)
+	assert.Equal(t, err, ErrUnverifiedEmail)
+
+	// A new email address creates a new, verified user.
+	id, err = m.LinkIdentity("corp", "sub-3", "nuevo@example.com", "Nuevo Usuario", true)
+	assert.NilError(t, err)
+
+	u, err := m.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Email, "nuevo@example.com")
+	assert.Equal(t, u.EmailVerified(), true)
+
+	// The new user hasn't chosen a password, but the seeded user has.
+	assert.Equal(t, u.PasswordSet, false)
+
+	seeded, err := m.Get(uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8"))
+	assert.NilError(t, err)
+	assert.Equal(t, seeded.PasswordSet, true)
+
+	identities, err := m.Identities(id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(identities), 1)
+	assert.Equal(t, identities[0].Provider, "corp")
+	assert.Equal(t, identities[0].Subject, "sub-3")
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Define an EmailVerificationModelInterface interface that describes the
+// methods our EmailVerificationModel has.
+type EmailVerificationModelInterface interface {
+	New(email string, ttl time.Duration) (string, error)
+	Verify(plaintext string) (uuid.UUID, error)
+	NewEmailChange(userID uuid.UUID, email string, ttl time.Duration) (string, error)
+	ConfirmEmailChange(plaintext string) (*EmailChange, error)
+}
+
+// Define an EmailChange type to hold the details of a confirmed change to a
+// user's email address.
+type EmailChange struct {
+	UserID   uuid.UUID
+	OldEmail string
+	NewEmail string
+}
+
+// Define an EmailVerificationModel type that wraps a database connection
+// pool. Verification tokens are only stored as a SHA-256 hash, along with
+// the email address they were sent to.
+type EmailVerificationModel struct {
+	DB *sql.DB
+}
+
+// The New() method creates a verification token for the user with the given
+// email address, which expires after the given duration, and returns the
+// plain-text token. If no user has that email address the ErrNoRecord error
+// is returned.
+func (m *EmailVerificationModel) New(email string, ttl time.Duration) (string, error) {
+	plaintext, hash, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO email_verifications (hash, user_id, email, created_on, expires_on)
+		SELECT $1, id, email, (now() at time zone 'utc'), (now() at time zone 'utc') + $2 * interval '1 second'
+		FROM users WHERE email = $3`
+
+	result, err := m.DB.Exec(query, hash, int(ttl.Seconds()), email)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", ErrNoRecord
+	}
+
+	return plaintext, nil
+}
+
+// The Verify() method uses up a verification token and marks the email
+// address of the user it belongs to as verified, returning the user's ID.
+// The address is only marked as verified if it's still the one that the
+// token was sent to. If the token doesn't exist, has expired or is for an
+// old address the ErrInvalidToken error is returned.
+func (m *EmailVerificationModel) Verify(plaintext string) (uuid.UUID, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	var email string
+
+	query := `DELETE FROM email_verifications
+		WHERE hash = $1 AND expires_on > (now() at time zone 'utc')
+		RETURNING user_id, email`
+
+	err = tx.QueryRow(query, hashToken(plaintext)).Scan(&userID, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	query = `UPDATE users SET email_verified_on = (now() at time zone 'utc')
+		WHERE id = $1 AND email = $2`
+
+	result, err := tx.Exec(query, userID, email)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if n == 0 {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	// Only remove the tokens for this address, so that any pending email
+	// change still works.
+	_, err = tx.Exec(`DELETE FROM email_verifications WHERE user_id = $1 AND email = $2`, userID, email)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// The NewEmailChange() method creates a token for changing a user's email
+// address to a new one, which expires after the given duration, and returns
+// the plain-text token. The token is sent to the new address, so the change
+// only happens once the user has shown that they own it. If another user
+// already has the new address the ErrDuplicateEmail error is returned.
+func (m *EmailVerificationModel) NewEmailChange(userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	var exists bool
+
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT true FROM users WHERE email = $1)`, email).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrDuplicateEmail
+	}
+
+	plaintext, hash, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO email_verifications (hash, user_id, email, created_on, expires_on)
+		VALUES ($1, $2, $3, (now() at time zone 'utc'), (now() at time zone 'utc') + $4 * interval '1 second')`
+
+	_, err = m.DB.Exec(query, hash, userID, email, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// The ConfirmEmailChange() method uses up an email change token and switches
+// the user's email address to the one it was sent to, marking it as
+// verified. Any other tokens for the user are removed, since they were sent
+// to an old address or are for a different change. If the token doesn't
+// exist, has expired or is for the user's current address the
+// ErrInvalidToken error is returned, and if another user has taken the new
+// address in the meantime the ErrDuplicateEmail error is returned.
+func (m *EmailVerificationModel) ConfirmEmailChange(plaintext string) (*EmailChange, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	c := &EmailChange{}
+
+	query := `DELETE FROM email_verifications
+		WHERE hash = $1 AND expires_on > (now() at time zone 'utc')
+		RETURNING user_id, email`
+
+	err = tx.QueryRow(query, hashToken(plaintext)).Scan(&c.UserID, &c.NewEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	// Lock the user's row while we change it, so that the old address we
+	// return is the one that was replaced.
+	err = tx.QueryRow(`SELECT email FROM users WHERE id = $1 FOR UPDATE`, c.UserID).Scan(&c.OldEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if c.OldEmail == c.NewEmail {
+		return nil, ErrInvalidToken
+	}
+
+	query = `UPDATE users SET email = $1, email_verified_on = (now() at time zone 'utc')
+		WHERE id = $2`
+
+	_, err = tx.Exec(query, c.NewEmail, c.UserID)
+	if err != nil {
+		var pSQLError *pq.Error
+		if errors.As(err, &pSQLError) {
+			if pSQLError.Code == "23505" && strings.Contains(pSQLError.Message, "users_uc_email") {
+				return nil, ErrDuplicateEmail
+			}
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM email_verifications WHERE user_id = $1`, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, tx.Commit()
+}
@@ -0,0 +1,235 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned if an ID token is malformed, has a bad
+// signature, or has claims which don't match what we expect.
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// Allow for a little clock skew between us and the provider when checking
+// when a token expires.
+const leeway = time.Minute
+
+// Claims holds the claims from a verified ID token which we use. Subject
+// identifies the user at the provider, and never changes, unlike their email
+// address. AuthTime is when the user last logged in at the provider (as a
+// Unix time), which is only sent if it was asked for.
+type Claims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	AuthTime      int64  `json:"auth_time"`
+}
+
+// The AuthenticatedSince() method reports whether the user logged in at the
+// provider at or after t, allowing for clock skew.
+func (c *Claims) AuthenticatedSince(t time.Time) bool {
+	return c.AuthTime != 0 && !time.Unix(c.AuthTime, 0).Add(leeway).Before(t)
+}
+
+// Define the claims which are checked but not returned. The audience can be
+// a single string or a list of strings.
+type tokenClaims struct {
+	Claims
+	Audience  audience `json:"aud"`
+	AuthParty string   `json:"azp"`
+	Expiry    int64    `json:"exp"`
+}
+
+// Define an audience type which decodes either form of the "aud" claim.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// The Verify() method checks an ID token's signature against the provider's
+// keys, and checks that it was issued by the provider for us, that it hasn't
+// expired, and that it contains the expected nonce. If everything is ok the
+// token's claims are returned.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	// Only look at the claims once we know that the provider signed them.
+	var claims tokenClaims
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := p.now()
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthParty != p.ClientID:
+		return nil, fmt.Errorf("%w: wrong authorized party", ErrInvalidToken)
+	case now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidToken)
+	}
+
+	return &claims.Claims, nil
+}
+
+// The decodeSegment() func decodes a base64url-encoded JSON part of a token.
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+// The key() method returns the provider's public key with the given key ID.
+// Providers rotate their keys, so if we don't have the key the JWKS is
+// fetched again.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+
+	if !ok {
+		err := p.fetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		key, ok = p.keys[kid]
+		p.mu.Unlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+		}
+	}
+
+	return key, nil
+}
+
+// Define the JSON representation of a key in a JWKS. Only RSA keys are
+// decoded, and any others are skipped.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// The fetchKeys() method fetches the provider's JWKS and replaces the keys
+// that we have.
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err = p.getJSON(ctx, d.JWKSURI, &jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("oidc: invalid key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return fmt.Errorf("oidc: invalid key %q: %w", k.Kid, err)
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 3 {
+			return fmt.Errorf("oidc: invalid key %q: bad exponent", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return nil
+}
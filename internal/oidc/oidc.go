@@ -0,0 +1,307 @@
+// Package oidc implements logging in with an OpenID Connect identity
+// provider, using the authorization code flow with PKCE (RFC 7636). ID tokens
+// are checked against the keys that the provider publishes in its JSON Web
+// Key Set. Only RS256 signatures are supported, since every provider offers
+// them.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrExchangeFailed is returned if the provider refuses to exchange an
+// authorization code for tokens (ex: because the code has expired).
+var ErrExchangeFailed = errors.New("oidc: code exchange failed")
+
+// Config holds the settings for an identity provider. Name identifies the
+// provider in URLs, and DisplayName is shown on the login page.
+type Config struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Use a regexp to check that provider names are safe to use in URLs.
+var nameRX = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// The LoadConfigs() func reads a JSON file containing a list of provider
+// configs, and checks that each of them is complete.
+func LoadConfigs(path string) ([]Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+
+	err = json.Unmarshal(b, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, c := range configs {
+		switch {
+		case !nameRX.MatchString(c.Name):
+			return nil, fmt.Errorf("oidc: invalid provider name %q", c.Name)
+		case seen[c.Name]:
+			return nil, fmt.Errorf("oidc: duplicate provider name %q", c.Name)
+		case c.Issuer == "" || c.ClientID == "":
+			return nil, fmt.Errorf("oidc: provider %q needs an issuer and client_id", c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	return configs, nil
+}
+
+// Define the parts of the provider's discovery document that we use.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider logs users in with an identity provider. The provider's discovery
+// document and keys are fetched the first time they're needed, so that the
+// application can start while the provider is unavailable.
+type Provider struct {
+	Config
+	RedirectURL string
+
+	client *http.Client
+	now    func() time.Time
+
+	mu   sync.Mutex
+	disc *discovery
+	keys map[string]*rsa.PublicKey
+}
+
+// The New() func returns a Provider for the given config. Users are sent
+// back to the redirectURL after logging in. If client is nil,
+// http.DefaultClient is used.
+func New(config Config, redirectURL string, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if config.DisplayName == "" {
+		config.DisplayName = config.Name
+	}
+
+	return &Provider{
+		Config:      config,
+		RedirectURL: redirectURL,
+		client:      client,
+		now:         time.Now,
+	}
+}
+
+// The discover() method returns the provider's discovery document, fetching
+// it if it hasn't been fetched yet. The issuer in the document must match
+// the configured one exactly, otherwise the ID tokens can't be trusted.
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.disc != nil {
+		return p.disc, nil
+	}
+
+	d := &discovery{}
+
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q doesn't match the configured issuer %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: incomplete discovery document for %q", p.Issuer)
+	}
+
+	p.disc = d
+	return d, nil
+}
+
+// The getJSON() method fetches a URL and decodes the JSON response into dst.
+func (p *Provider) getJSON(ctx context.Context, u string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	rs, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: unexpected status %d", u, rs.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(rs.Body, 1<<20)).Decode(dst)
+}
+
+// The RandomString() func returns a random URL-safe string containing 32
+// random bytes. It's used for the state, nonce and PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The CodeChallenge() func returns the S256 PKCE code challenge for a code
+// verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// The AuthCodeURL() method returns the URL of the provider's login page.
+// The state and nonce are checked when the user comes back, and the code
+// verifier has to be sent when the code is exchanged.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	return p.authURL(ctx, state, nonce, verifier, nil)
+}
+
+// The ReauthURL() method is like AuthCodeURL(), but asks the provider to
+// make the user log in again even if they're already logged in there, and
+// to include the auth_time claim in the ID token. It's used to confirm who
+// a user is before a sensitive action.
+func (p *Provider) ReauthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	return p.authURL(ctx, state, nonce, verifier, url.Values{
+		"prompt":  {"login"},
+		"max_age": {"0"},
+	})
+}
+
+// The authURL() method builds the URL of the provider's login page, with any
+// extra parameters added.
+func (p *Provider) authURL(ctx context.Context, state, nonce, verifier string, extra url.Values) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := append([]string{"openid", "email", "profile"}, p.Scopes...)
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(dedupe(scopes), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+	for key, values := range extra {
+		v[key] = values
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// The dedupe() func removes repeated values from a slice, keeping the first
+// of each.
+func dedupe(values []string) []string {
+	seen := make(map[string]bool)
+	out := values[:0:0]
+
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// The Exchange() method swaps an authorization code for the user's ID token,
+// and returns the claims from it once they've been verified. The nonce must
+// be the one which was passed to AuthCodeURL().
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("code_verifier", verifier)
+
+	// Public clients don't have a secret, so they only send their ID.
+	if p.ClientSecret == "" {
+		v.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	rs, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+
+	if rs.StatusCode != http.StatusOK {
+		return nil, ErrExchangeFailed
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(rs.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, ErrExchangeFailed
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
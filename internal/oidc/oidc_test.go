@@ -0,0 +1,236 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc"
+	"github.com/Avixph/learn-go-snippetbox/internal/oidc/oidctest"
+)
+
+const redirectURL = "https://snippetbox.test/user/login/oidc/test/callback"
+
+// The authorize() helper follows the provider's login page, and returns the
+// code and state that the provider redirects back with.
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) (string, string) {
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	assert.NilError(t, err)
+
+	return follow(t, authURL)
+}
+
+// The follow() helper follows a login page URL, and returns the code and
+// state that the provider redirects back with.
+func follow(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	rs, err := client.Get(authURL)
+	assert.NilError(t, err)
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+
+	location, err := url.Parse(rs.Header.Get("Location"))
+	assert.NilError(t, err)
+	assert.Equal(t, strings.SplitN(location.String(), "?", 2)[0], redirectURL)
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestExchange(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	srv.SetUser(oidctest.User{
+		Subject:       "user-1",
+		Email:         "falso@example.com",
+		EmailVerified: true,
+		Name:          "Nom Falso",
+	})
+
+	tests := []struct {
+		name     string
+		modify   func(claims map[string]any)
+		verifier string
+		wantErr  error
+	}{
+		{
+			name: "Valid",
+		},
+		{
+			name:   "Audience list",
+			modify: func(c map[string]any) { c["aud"] = []string{oidctest.ClientID, "other"}; c["azp"] = oidctest.ClientID },
+		},
+		{
+			name:    "Wrong audience",
+			modify:  func(c map[string]any) { c["aud"] = "other" },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "Wrong issuer",
+			modify:  func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "Expired",
+			modify:  func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "Wrong nonce",
+			modify:  func(c map[string]any) { c["nonce"] = "replayed" },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:     "Wrong code verifier",
+			verifier: "not-the-verifier",
+			wantErr:  oidc.ErrExchangeFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Modify = tt.modify
+			p := oidc.New(srv.Config("test"), redirectURL, nil)
+
+			code, state := authorize(t, p, "the-state", "the-nonce", "the-verifier")
+			assert.Equal(t, state, "the-state")
+
+			verifier := "the-verifier"
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+
+			claims, err := p.Exchange(context.Background(), code, verifier, "the-nonce")
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+
+			if tt.wantErr == nil {
+				assert.NilError(t, err)
+				assert.Equal(t, claims.Subject, "user-1")
+				assert.Equal(t, claims.Email, "falso@example.com")
+				assert.Equal(t, claims.EmailVerified, true)
+			}
+		})
+	}
+}
+
+func TestReauth(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	srv.SetUser(oidctest.User{Subject: "user-1", Email: "falso@example.com", EmailVerified: true})
+
+	p := oidc.New(srv.Config("test"), redirectURL, nil)
+	start := time.Now()
+
+	// A normal login doesn't ask for the auth_time claim.
+	code, _ := authorize(t, p, "the-state", "the-nonce", "the-verifier")
+
+	claims, err := p.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+	assert.NilError(t, err)
+	assert.Equal(t, claims.AuthenticatedSince(start), false)
+
+	// But a reauthentication forces a new login, and gets told when it was.
+	authURL, err := p.ReauthURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	assert.NilError(t, err)
+
+	u, err := url.Parse(authURL)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Query().Get("prompt"), "login")
+	assert.Equal(t, u.Query().Get("max_age"), "0")
+
+	code, _ = follow(t, authURL)
+
+	claims, err = p.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+	assert.NilError(t, err)
+	assert.Equal(t, claims.AuthenticatedSince(start), true)
+	assert.Equal(t, claims.AuthenticatedSince(start.Add(time.Hour)), false)
+}
+
+func TestVerifySignature(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	p := oidc.New(srv.Config("test"), redirectURL, nil)
+
+	claims := map[string]any{
+		"iss":   srv.URL,
+		"aud":   oidctest.ClientID,
+		"sub":   "user-1",
+		"nonce": "n",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+
+	token := srv.Sign(claims)
+
+	_, err := p.Verify(context.Background(), token, "n")
+	assert.NilError(t, err)
+
+	// Swap in a different payload, keeping the original signature.
+	claims["sub"] = "admin"
+	parts := strings.Split(token, ".")
+	forged := strings.Split(srv.Sign(claims), ".")
+	parts[1] = forged[1]
+
+	_, err = p.Verify(context.Background(), strings.Join(parts, "."), "n")
+	assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+
+	// Check that unsigned tokens are rejected.
+	parts[0] = "eyJhbGciOiJub25lIn0"
+	_, err = p.Verify(context.Background(), strings.Join(parts[:2], ".")+".", "n")
+	assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+}
+
+func TestLoadConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{
+			name: "Valid",
+			json: `[{"name": "corp", "issuer": "https://id.example.com", "client_id": "abc"}]`,
+		},
+		{
+			name:    "Invalid name",
+			json:    `[{"name": "Corp SSO", "issuer": "https://id.example.com", "client_id": "abc"}]`,
+			wantErr: `oidc: invalid provider name "Corp SSO"`,
+		},
+		{
+			name:    "Duplicate name",
+			json:    `[{"name": "corp", "issuer": "https://a.example.com", "client_id": "abc"}, {"name": "corp", "issuer": "https://b.example.com", "client_id": "abc"}]`,
+			wantErr: `oidc: duplicate provider name "corp"`,
+		},
+		{
+			name:    "Missing issuer",
+			json:    `[{"name": "corp", "client_id": "abc"}]`,
+			wantErr: `oidc: provider "corp" needs an issuer and client_id`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "providers.json")
+			assert.NilError(t, os.WriteFile(path, []byte(tt.json), 0o600))
+
+			configs, err := oidc.LoadConfigs(path)
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+				assert.Equal(t, len(configs), 1)
+			} else {
+				assert.Equal(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
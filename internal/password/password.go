@@ -0,0 +1,150 @@
+// Package password hashes passwords for storage, and checks passwords against
+// the stored hashes. Hashes are stored as self-describing strings which
+// include the algorithm and its parameters, so the parameters can be changed
+// without breaking existing hashes.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrMismatchedPassword is returned when a password doesn't match a hash.
+	ErrMismatchedPassword = errors.New("password: password does not match hash")
+
+	// ErrInvalidHash is returned when a hash isn't in a format we recognise.
+	ErrInvalidHash = errors.New("password: invalid hash")
+)
+
+// Define a Hasher interface that describes the methods a password hashing
+// scheme has. The Compare() method returns ErrMismatchedPassword if the
+// password doesn't match, and reports whether the hash should be replaced
+// with a new one from Hash() (ex: because it uses an older algorithm or
+// weaker parameters).
+type Hasher interface {
+	Hash(password string) (string, error)
+	Compare(hash, password string) (needsRehash bool, err error)
+}
+
+// Define an Argon2id type which hashes passwords with argon2id. The Memory
+// parameter is in KiB. Hashes are encoded in the same format as the reference
+// implementation:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+//
+// where the salt and key are unpadded base64. As well as its own hashes,
+// Compare() accepts bcrypt hashes, so that existing users can still log in
+// (their hashes are reported as needing a rehash).
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Define the default parameters. These are the second recommended option in
+// RFC 9106, for use where 2 GiB of memory per hash isn't available.
+var DefaultArgon2id = &Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var b64 = base64.RawStdEncoding
+
+// The Hash() method hashes a password using a new random salt.
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// The Compare() method checks a password against an argon2id or bcrypt hash.
+func (a *Argon2id) Compare(hash, password string) (bool, error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrMismatchedPassword
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, ErrMismatchedPassword
+	}
+
+	needsRehash := params.Memory != a.Memory ||
+		params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism ||
+		uint32(len(salt)) != a.SaltLength ||
+		uint32(len(key)) != a.KeyLength
+
+	return needsRehash, nil
+}
+
+// The isBcrypt() func reports whether a hash is a bcrypt hash, which start
+// with "$2a$", "$2b$" or "$2y$".
+func isBcrypt(hash string) bool {
+	return len(hash) > 4 && hash[0] == '$' && hash[1] == '2' && hash[3] == '$'
+}
+
+// The decodeArgon2id() func splits an encoded argon2id hash into its
+// parameters, salt and key.
+func decodeArgon2id(hash string) (*Argon2id, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	params := &Argon2id{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,92 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Use cheap parameters in the tests, so that they run quickly.
+var testArgon2id = &Argon2id{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := testArgon2id.Hash("1376p@$$w0rd8923")
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), true)
+
+	// Two hashes of the same password should use different salts.
+	other, err := testArgon2id.Hash("1376p@$$w0rd8923")
+	assert.NilError(t, err)
+	assert.Equal(t, hash == other, false)
+
+	needsRehash, err := testArgon2id.Compare(hash, "1376p@$$w0rd8923")
+	assert.NilError(t, err)
+	assert.Equal(t, needsRehash, false)
+
+	_, err = testArgon2id.Compare(hash, "wrong password")
+	assert.Equal(t, err, ErrMismatchedPassword)
+}
+
+func TestArgon2idLongPassword(t *testing.T) {
+	// bcrypt ignores everything after the first 72 bytes, but argon2id
+	// shouldn't.
+	password := strings.Repeat("a", 72)
+
+	hash, err := testArgon2id.Hash(password + "b")
+	assert.NilError(t, err)
+
+	_, err = testArgon2id.Compare(hash, password+"c")
+	assert.Equal(t, err, ErrMismatchedPassword)
+}
+
+func TestArgon2idCompareBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("1376p@$$w0rd8923"), bcrypt.MinCost)
+	assert.NilError(t, err)
+
+	needsRehash, err := testArgon2id.Compare(string(hash), "1376p@$$w0rd8923")
+	assert.NilError(t, err)
+	assert.Equal(t, needsRehash, true)
+
+	_, err = testArgon2id.Compare(string(hash), "wrong password")
+	assert.Equal(t, err, ErrMismatchedPassword)
+}
+
+func TestArgon2idCompareOldParameters(t *testing.T) {
+	old := &Argon2id{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	hash, err := old.Hash("1376p@$$w0rd8923")
+	assert.NilError(t, err)
+
+	needsRehash, err := testArgon2id.Compare(hash, "1376p@$$w0rd8923")
+	assert.NilError(t, err)
+	assert.Equal(t, needsRehash, true)
+}
+
+func TestArgon2idCompareInvalidHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"Empty", ""},
+		{"Unknown algorithm", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5"},
+		{"Wrong version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"},
+		{"Bad parameters", "$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5"},
+		{"Bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5"},
+		{"Missing key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testArgon2id.Compare(tt.hash, "1376p@$$w0rd8923")
+			assert.Equal(t, err, ErrInvalidHash)
+		})
+	}
+}
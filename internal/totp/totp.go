@@ -0,0 +1,146 @@
+// Package totp implements the time-based one-time passwords (TOTP) described
+// in RFC 6238, as used by authenticator apps. Codes are 6 digits long, use
+// HMAC-SHA1 and change every 30 seconds, which are the defaults that every
+// authenticator app supports.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the number of digits in a code.
+	Digits = 6
+
+	// Period is how long each code is valid for.
+	Period = 30 * time.Second
+)
+
+// ErrInvalidSecret is returned if a secret isn't valid base32.
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+// Use base32 without padding for secrets, as expected by authenticator apps.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// The GenerateSecret() func returns a new random secret containing 20 bytes
+// (160 bits, as recommended by RFC 4226) encoded as base32.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// The decodeSecret() func decodes a base32 secret. Spaces are ignored and
+// lower case letters are accepted, since people sometimes type secrets in by
+// hand.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	return key, nil
+}
+
+// The hotp() func computes an HOTP value (RFC 4226) for the given key and
+// counter, with the given number of digits.
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Use dynamic truncation to take 31 bits from the HMAC, starting at the
+	// offset given by its last 4 bits.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// The Step() func returns the number of the time step that t is in. Each
+// step is Period long, counting from the Unix epoch.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// The Code() func returns the code for the given secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, uint64(Step(t)), Digits), nil
+}
+
+// The Validate() func checks a code against the given secret at time t. To
+// allow for clock drift between the server and the user's device, codes from
+// up to skew steps before or after t are also accepted. If the code is valid
+// the time step it belongs to is returned, so that the caller can reject
+// codes which have already been used.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := now + int64(i)
+		if step < 0 {
+			continue
+		}
+
+		want := hotp(key, uint64(step), Digits)
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// The URI() func returns the otpauth:// URI for a secret, which authenticator
+// apps can import (usually by scanning it as a QR code). The issuer and
+// account name are shown in the app to identify the code.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+// The SHA-1 test vectors from Appendix B of RFC 6238, which use the ASCII
+// secret "12345678901234567890" and 8 digit codes.
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			counter := uint64(Step(time.Unix(tt.unix, 0)))
+
+			assert.Equal(t, hotp(key, counter, 8), tt.want)
+		})
+	}
+}
+
+func TestCodeAndValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(secret, now)
+	assert.NilError(t, err)
+	assert.Equal(t, code, "050471")
+
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		wantOK   bool
+		wantStep int64
+	}{
+		{"Current code", code, now, true, Step(now)},
+		{"Previous step", code, now.Add(Period), true, Step(now)},
+		{"Next step", code, now.Add(-Period), true, Step(now)},
+		{"Too old", code, now.Add(2 * Period), false, 0},
+		{"With spaces", "050 471", now, true, Step(now)},
+		{"Wrong code", "123456", now, false, 0},
+		{"Too short", "05047", now, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(secret, tt.code, tt.at, 1)
+
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, step, tt.wantStep)
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NilError(t, err)
+
+	// 20 bytes encode to 32 base32 characters.
+	assert.Equal(t, len(secret), 32)
+
+	_, err = Code(secret, time.Now())
+	assert.NilError(t, err)
+
+	_, err = Code("not base32!", time.Now())
+	assert.Equal(t, err, ErrInvalidSecret)
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Snippetbox", "falso@example.com", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Snippetbox:falso@example.com?algorithm=SHA1&digits=6&issuer=Snippetbox&period=30&secret=JBSWY3DPEHPK3PXP"
+
+	assert.Equal(t, got, want)
+}
@@ -0,0 +1,137 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Define a BreachedPasswords type which looks passwords up in a corpus of
+// passwords known to have appeared in data breaches. Only the SHA-1 hashes
+// of the passwords are kept, split into bucket files by the first 5 hex
+// characters of the hash (the same scheme used by the Have I Been Pwned range
+// API). Each bucket is only read when a password with its prefix is checked,
+// so the corpus can be far larger than memory. A nil *BreachedPasswords is
+// an empty corpus.
+type BreachedPasswords struct {
+	fsys fs.FS
+}
+
+// The OpenBreachedPasswords() func returns a corpus kept in the directory at
+// the given path. See NewBreachedPasswords() for the layout.
+func OpenBreachedPasswords(dir string) (*BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("validator: %s is not a directory", dir)
+	}
+
+	return NewBreachedPasswords(os.DirFS(dir)), nil
+}
+
+// The NewBreachedPasswords() func returns a corpus kept in fsys, with one
+// file for each bucket named after its prefix (ex: "ABF7A.txt"). Each line of
+// a bucket file holds the rest of a hash in hex. Anything after a colon on
+// each line (such as the count in the Have I Been Pwned downloads) is
+// ignored, as are blank lines and lines starting with "#". This is the
+// layout written by the Have I Been Pwned downloader with "-s false".
+func NewBreachedPasswords(fsys fs.FS) *BreachedPasswords {
+	return &BreachedPasswords{fsys: fsys}
+}
+
+// The Contains() method returns true if the password is in the corpus. A
+// missing bucket file means that no hashes have its prefix.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	hash := sha1.Sum([]byte(password))
+	hexHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hexHash[:5], hexHash[5:]
+
+	f, err := b.fsys.Open(prefix + ".txt")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(text, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// Define the shortest part of a name or email address which passwords are
+// checked for. Shorter parts (ex: "Al" or "Li") would reject too many
+// unrelated passwords.
+const minPersonalPart = 4
+
+// The ContainsPersonalInfo() func returns true if a password contains any of
+// the given personal details (ex: the user's name or email address), ignoring
+// case. Each detail is also split into words, so "Nom Falso" rejects
+// passwords containing "falso" and "nomfalso", and "falso@example.com"
+// rejects passwords containing "falso" or "example".
+func ContainsPersonalInfo(password string, details ...string) bool {
+	password = strings.ToLower(password)
+
+	for _, detail := range details {
+		detail = strings.ToLower(detail)
+
+		words := strings.FieldsFunc(detail, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+
+		parts := append(words, detail, strings.Join(words, ""))
+		for _, part := range parts {
+			if utf8.RuneCountInString(part) >= minPersonalPart && strings.Contains(password, part) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Define a PasswordPolicy type which holds the rules that new passwords must
+// follow.
+type PasswordPolicy struct {
+	MinChars int
+	Breached *BreachedPasswords
+}
+
+// The CheckPassword() method checks a new password against the policy, and
+// adds an error message for the given form field if it breaks any of the
+// rules. The details are the user's personal details (ex: their name and
+// email address), which the password mustn't contain. An error is only
+// returned if the breached passwords corpus can't be read.
+func (p *PasswordPolicy) CheckPassword(v *Validator, key, password string, details ...string) error {
+	v.CheckField(NotBlank(password), key, "This field cannot be blank")
+	v.CheckField(MinChars(password, p.MinChars), key, fmt.Sprintf("This field must be at least %d characters long", p.MinChars))
+	v.CheckField(!ContainsPersonalInfo(password, details...), key, "This field must not contain your name or email address")
+
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		return err
+	}
+	v.CheckField(!breached, key, "This password has appeared in a data breach, please choose a different one")
+
+	return nil
+}
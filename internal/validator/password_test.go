@@ -0,0 +1,111 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+// Define a small corpus containing the SHA-1 hashes of "password123" and
+// "correct horse battery staple", in the Have I Been Pwned format.
+var testCorpus = fstest.MapFS{
+	"CBFDA.txt": {Data: []byte("# Test bucket\nC6008F9CAB4083784CBD1874F76618D2A97:2254650\n\n")},
+	"ABF7A.txt": {Data: []byte("0000000000000000000000000000000000A:1\nad6438836dbe526aa231abde2d0eef74d42:12\n")},
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	b := NewBreachedPasswords(testCorpus)
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password123", true},
+		{"correct horse battery staple", true},
+		{"Password123", false},
+		{"a long and unusual passphrase", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			found, err := b.Contains(tt.password)
+			assert.NilError(t, err)
+			assert.Equal(t, found, tt.want)
+		})
+	}
+
+	t.Run("Nil corpus", func(t *testing.T) {
+		var b *BreachedPasswords
+
+		found, err := b.Contains("password123")
+		assert.NilError(t, err)
+		assert.Equal(t, found, false)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "CBFDA.txt"), testCorpus["CBFDA.txt"].Data, 0o644)
+		assert.NilError(t, err)
+
+		b, err := OpenBreachedPasswords(dir)
+		assert.NilError(t, err)
+
+		found, err := b.Contains("password123")
+		assert.NilError(t, err)
+		assert.Equal(t, found, true)
+
+		_, err = OpenBreachedPasswords(filepath.Join(dir, "CBFDA.txt"))
+		assert.Equal(t, err.Error(), "validator: "+filepath.Join(dir, "CBFDA.txt")+" is not a directory")
+	})
+}
+
+func TestContainsPersonalInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"Full name", "my name is NomFalso!!", true},
+		{"Part of name", "falso-falso-falso-1234", true},
+		{"Email address", "falso@example.com is my password", true},
+		{"Email domain", "my example password", true},
+		{"Short part of name", "nom nom nom nom nom nom", false},
+		{"Unrelated", "a long and unusual passphrase", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, ContainsPersonalInfo(tt.password, "Nom Falso", "falso@example.com"), tt.want)
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &PasswordPolicy{MinChars: 16, Breached: NewBreachedPasswords(testCorpus)}
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"Valid", "a long and unusual passphrase", ""},
+		{"Blank", "", "This field cannot be blank"},
+		{"Short", "pa$$word", "This field must be at least 16 characters long"},
+		{"Personal", "nom falso's password", "This field must not contain your name or email address"},
+		{"Breached", "correct horse battery staple", "This password has appeared in a data breach, please choose a different one"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+
+			err := policy.CheckPassword(&v, "password", tt.password, "Nom Falso", "falso@example.com")
+			assert.NilError(t, err)
+
+			assert.Equal(t, v.FieldErrors["password"], tt.want)
+		})
+	}
+}
@@ -2,7 +2,9 @@ package validator
 
 import (
 	"regexp"
+	"slices"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -96,3 +98,43 @@ func MinChars(value string, n int) bool {
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// Use the regexp.MustCompile() func to parse a pattern for a valid tag. A
+// tag starts with a lowercase letter or digit, followed by up to 31 more
+// lowercase letters, digits or the characters + # . _ - (ex: "sql", "c++",
+// "k8s").
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,31}$`)
+
+// The NormalizeTags() func splits a string of tags separated by commas
+// and/or whitespace, converts them to lowercase and removes any duplicates.
+// The tags are returned in the order they first appear.
+func NormalizeTags(value string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	tags := []string{}
+	for _, field := range fields {
+		if !slices.Contains(tags, field) {
+			tags = append(tags, field)
+		}
+	}
+	return tags
+}
+
+// The generic MaxItems() func returns true if a slice contains no more than
+// n items.
+func MaxItems[T any](values []T, n int) bool {
+	return len(values) <= n
+}
+
+// The AllMatch() func returns true if every value in a slice matches a
+// provided compiled regular expression pattern.
+func AllMatch(values []string, rx *regexp.Regexp) bool {
+	for _, value := range values {
+		if !rx.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Avixph/learn-go-snippetbox/internal/assert"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{
+			name:  "Commas",
+			value: "sql,bash,k8s",
+			want:  []string{"sql", "bash", "k8s"},
+		},
+		{
+			name:  "Mixed separators",
+			value: " SQL, bash\tk8s ,, ",
+			want:  []string{"sql", "bash", "k8s"},
+		},
+		{
+			name:  "Duplicates",
+			value: "sql Sql SQL bash",
+			want:  []string{"sql", "bash"},
+		},
+		{
+			name:  "Empty",
+			value: "  ",
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := NormalizeTags(tt.value)
+
+			assert.Equal(t, strings.Join(tags, "|"), strings.Join(tt.want, "|"))
+		})
+	}
+}
+
+func TestTagRX(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{tag: "sql", want: true},
+		{tag: "c++", want: true},
+		{tag: "k8s", want: true},
+		{tag: "node.js", want: true},
+		{tag: "-flag", want: false},
+		{tag: "Bash", want: false},
+		{tag: "two words", want: false},
+		{tag: strings.Repeat("a", 32), want: true},
+		{tag: strings.Repeat("a", 33), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			assert.Equal(t, Matches(tt.tag, TagRX), tt.want)
+		})
+	}
+}